@@ -0,0 +1,126 @@
+package sbgnuplot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+
+	sberr "github.com/barbell-math/smoothbrain-errs"
+)
+
+var (
+	GnuPlotNotFoundErr       = errors.New("gnuplot not found")
+	UnknownGnuPlotVersionErr = errors.New("Unknown gnuplot version")
+	UnknownTerminalListErr   = errors.New("Unknown terminal list")
+)
+
+// Checks that gnuplot is installed by running `gnuplot --version`. The version
+// of gnuplot will be returned in the form `<major>.<minor>.<patchlevel>`. If
+// gnuplot could not be found a [GnuPlotNotFoundErr] will be returned. If
+// gnuplot could not be started a [GnuPlotStartErr] will be returned and if it
+// exited with an error a [GnuPlotRunErr] will be returned. If the version
+// output could not be parsed a [UnknownGnuPlotVersionErr] will be returned.
+func CheckGnuPlot(ctxt context.Context) (version string, err error) {
+	return checkGnuPlot(ctxt, "gnuplot")
+}
+
+func checkGnuPlot(ctxt context.Context, binary string) (string, error) {
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctxt, binary, "--version")
+	cmd.Stdout = &out
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", sberr.AppendError(
+				GnuPlotNotFoundErr,
+				sberr.InverseWrap(err, "Binary: %s", binary),
+			)
+		}
+		return "", checkRunErr(err, binary)
+	}
+
+	// Expected format: gnuplot <major>.<minor> patchlevel <patch>
+	fields := strings.Fields(out.String())
+	if len(fields) < 2 || fields[0] != "gnuplot" {
+		return "", sberr.Wrap(
+			UnknownGnuPlotVersionErr,
+			"Got: %s", strings.TrimSpace(out.String()),
+		)
+	}
+	version := fields[1]
+	if len(fields) >= 4 && fields[2] == "patchlevel" {
+		version += "." + fields[3]
+	}
+	return version, nil
+}
+
+// Returns the names of the terminals that the installed gnuplot supports by
+// running `gnuplot -e 'set terminal'`. The available terminals depend on how
+// gnuplot was compiled, so this can be used to check that a terminal, such as
+// `pngcairo` or `qt`, exists before using it. If gnuplot could not be found a
+// [GnuPlotNotFoundErr] will be returned. Errors from starting or running
+// gnuplot are wrapped in the same way as [CheckGnuPlot]. If the terminal list
+// could not be parsed a [UnknownTerminalListErr] will be returned.
+func AvailableTerminals(ctxt context.Context) ([]string, error) {
+	return availableTerminals(ctxt, "gnuplot")
+}
+
+func availableTerminals(ctxt context.Context, binary string) ([]string, error) {
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctxt, binary, "-e", "set terminal")
+	// gnuplot writes the terminal list to stderr
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, sberr.AppendError(
+				GnuPlotNotFoundErr,
+				sberr.InverseWrap(err, "Binary: %s", binary),
+			)
+		}
+		return nil, checkRunErr(err, binary)
+	}
+
+	// Expected format:
+	//	Available terminal types:
+	//	           <name>  <description>
+	_, list, found := strings.Cut(out.String(), "Available terminal types:")
+	if !found {
+		return nil, sberr.Wrap(
+			UnknownTerminalListErr,
+			"Got: %s", strings.TrimSpace(out.String()),
+		)
+	}
+	rv := []string{}
+	for _, l := range strings.Split(list, "\n") {
+		fields := strings.Fields(l)
+		if len(fields) < 2 || strings.HasPrefix(l, "Press return") {
+			continue
+		}
+		rv = append(rv, fields[0])
+	}
+	if len(rv) == 0 {
+		return nil, sberr.Wrap(
+			UnknownTerminalListErr, "No terminals were listed",
+		)
+	}
+	return rv, nil
+}
+
+// Wraps an error from running gnuplot that was not caused by the binary
+// missing in [GnuPlotRunErr] if gnuplot exited with an error, or
+// [GnuPlotStartErr] otherwise.
+func checkRunErr(err error, binary string) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return sberr.AppendError(
+			GnuPlotRunErr, sberr.InverseWrap(err, "Binary: %s", binary),
+		)
+	}
+	return sberr.AppendError(
+		GnuPlotStartErr, sberr.InverseWrap(err, "Binary: %s", binary),
+	)
+}
@@ -0,0 +1,121 @@
+package sbgnuplot
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"slices"
+	"testing"
+)
+
+func TestCheckGnuPlot(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want string
+		err  error
+	}{
+		{"patchlevel", "gnuplot 5.4 patchlevel 8", "5.4.8", nil},
+		{"no patchlevel", "gnuplot 6.0", "6.0", nil},
+		{"unknown", "not gnuplot", "", UnknownGnuPlotVersionErr},
+		{"empty", "", "", UnknownGnuPlotVersionErr},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bin := newFakeBinary(t, "echo '"+tc.out+"'")
+			got, err := checkGnuPlot(context.Background(), bin)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected %v, got: %v", tc.err, err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestAvailableTerminals(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want []string
+		err  error
+	}{
+		{
+			"terminals",
+			"\nAvailable terminal types:\n" +
+				"           png  PNG images using libgd\n" +
+				"           svg  W3C Scalable Vector Graphics\n" +
+				"Press return for more:\n",
+			[]string{"png", "svg"},
+			nil,
+		},
+		{"no header", "png  PNG images\n", nil, UnknownTerminalListErr},
+		{
+			"no terminals", "Available terminal types:\n", nil,
+			UnknownTerminalListErr,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// gnuplot writes the terminal list to stderr
+			bin := newFakeBinary(t, "printf '"+tc.out+"' >&2")
+			got, err := availableTerminals(context.Background(), bin)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected %v, got: %v", tc.err, err)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCheckErrors(t *testing.T) {
+	missing := "sbgnuplot-does-not-exist"
+	failing := newFakeBinary(t, "exit 1")
+	tests := []struct {
+		name string
+		fn   func(binary string) error
+	}{
+		{"checkGnuPlot", func(binary string) error {
+			_, err := checkGnuPlot(context.Background(), binary)
+			return err
+		}},
+		{"availableTerminals", func(binary string) error {
+			_, err := availableTerminals(context.Background(), binary)
+			return err
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name+"/not found", func(t *testing.T) {
+			var execErr *exec.Error
+			err := tc.fn(missing)
+			if !errors.Is(err, GnuPlotNotFoundErr) || !errors.As(err, &execErr) {
+				t.Fatalf("expected GnuPlotNotFoundErr, got: %v", err)
+			}
+		})
+		t.Run(tc.name+"/exit error", func(t *testing.T) {
+			var exitErr *exec.ExitError
+			err := tc.fn(failing)
+			if !errors.Is(err, GnuPlotRunErr) || !errors.As(err, &exitErr) {
+				t.Fatalf("expected GnuPlotRunErr, got: %v", err)
+			}
+		})
+	}
+	t.Run("exported", func(t *testing.T) {
+		if _, err := exec.LookPath("gnuplot"); err == nil {
+			t.Skip("gnuplot is installed")
+		}
+		if _, err := CheckGnuPlot(context.Background()); !errors.Is(
+			err, GnuPlotNotFoundErr,
+		) {
+			t.Fatalf("expected GnuPlotNotFoundErr, got: %v", err)
+		}
+		if _, err := AvailableTerminals(context.Background()); !errors.Is(
+			err, GnuPlotNotFoundErr,
+		) {
+			t.Fatalf("expected GnuPlotNotFoundErr, got: %v", err)
+		}
+	})
+}
@@ -2,200 +2,2814 @@
 package sbgnuplot
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/binary"
 	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
+	"io"
+	"maps"
+	"math"
+	"math/rand/v2"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	sberr "github.com/barbell-math/smoothbrain-errs"
 )
 
 type (
+	// The ways that gnuplot can be given the generated gnu plot code when
+	// running.
+	RunMode int
+
 	// The main struct that is used to control plot generation.
 	GnuPlot struct {
-		outFile    string
-		gpltFile   *os.File
-		datFiles   []*os.File
-		csvWriters []*csv.Writer
+		opts           GnuPlotOpts
+		outFiles       []string
+		binary         string
+		floatPrecision int
+		width          int
+		height         int
+		inMultiplot    bool
+		plotted        bool
+		commentHeader  bool
+		concurrent     bool
+		rejectSep      bool
+		inMemory       bool
+		cleanupOut     bool
+		warnEmptyDat   bool
+		errOnEmptyDat  bool
+		compressDat    bool
+		cleanupAfter   bool
+		inlineData     bool
+		runMode        RunMode
+		args           []string
+		stdout         io.Writer
+		stderr         io.Writer
+		gpltName       string
+		gplt           io.Writer
+		gpltFile       *os.File
+		datNames       []string
+		datWriters     []io.Writer
+		datCounters    []*countingWriter
+		csvWriters     []*csv.Writer
+		headers        [][]string
+		rowCnts        []int
+		hasData        []bool
+		datRefs        []bool
+		datLocks       []sync.Mutex
+		jsonMirrors    []*jsonMirror
+		binCols        []int
+		closed         bool
+	}
+
+	// Wraps a dat writer so that closing it closes both the gzip writer and
+	// the underlying writer.
+	gzipDat struct {
+		*gzip.Writer
+		under io.Writer
+	}
+
+	// Counts the bytes that are written to the underlying writer. Once a
+	// write has exceeded the max all later writes are rejected.
+	countingWriter struct {
+		w        io.Writer
+		n        int64
+		max      int64
+		name     string
+		exceeded bool
+	}
+
+	// Writes the rows of a dat file to a json file as an array of arrays.
+	jsonMirror struct {
+		f    *os.File
+		w    *bufio.Writer
+		rows int
 	}
 
 	GnuPlotOpts struct {
 		// Specifies the file where the generated gnuplot code will go. This
-		// path will be relative to the current directory.
+		// path will be relative to the current directory unless
+		// [GnuPlotOpts.WorkDir] is set.
 		GpltFile string
 		// Specifies the files where the data for the plot will be written to.
 		// The order of the files matters because methods on [GnuPlot] will
 		// reference a data file by index.
-		// All paths will be relative to the current directory.
+		// All paths will be relative to the current directory unless
+		// [GnuPlotOpts.WorkDir] is set.
 		DatFiles []string
-		// Specifies the file where the generated plot will be written to. This
-		// path will be relative to the current directory.
-		OutFile string
+		// Specifies the files where the generated plots will be written to.
+		// The order of the files matters because the `{out:#}` op will
+		// reference an out file by index. Most plots only need a single out
+		// file, which can be referenced with the `{out}` op.
+		// All paths will be relative to the current directory unless
+		// [GnuPlotOpts.WorkDir] is set.
+		OutFiles []string
 		// The column delimiter character that should be used when writing the
-		// data to the dat files.
+		// data to the dat files. Defaults to a tab when left as the zero
+		// value, which gnuplot treats as whitespace and therefore parses
+		// without needing to set the datafile separator. A double quote,
+		// carriage return, newline, or invalid rune will cause [NewGnuPlot] to
+		// return a [InvalidOptsErr].
 		CsvSep rune
+		// The number of digits after the decimal point that should be used
+		// when formatting floats that are written to the dat files with
+		// [GnuPlot.DataRowf] and [GnuPlot.DataMatrix]. When set the floats are
+		// formatted with [strconv.FormatFloat] using a format of 'f', so a
+		// precision of 0 writes whole numbers. When nil the shortest
+		// representation that exactly represents the value will be used,
+		// switching to an exponent for very large or small values such as
+		// `1e-20`. A negative precision, such as -1, behaves the same as nil.
+		FloatPrecision *int
+		// The gnuplot executable that should be used when running the
+		// generated gnu plot code. This can either be a name that will be
+		// looked up in the PATH or a path to the executable. If left empty
+		// `gnuplot` will be used.
+		GnuPlotBinary string
+		// The writer that gnuplot's stdout will be written to when running the
+		// generated gnu plot code. If left nil [os.Stdout] will be used.
+		Stdout io.Writer
+		// The writer that gnuplot's stderr will be written to when running the
+		// generated gnu plot code. If left nil [os.Stderr] will be used.
+		Stderr io.Writer
+		// When true the header rows written with [GnuPlot.DataHeader] will be
+		// prefixed with `#`, causing gnuplot to treat them as comments.
+		CommentHeader bool
+		// When true the dat files will be backed by in memory buffers rather
+		// than files. When [GnuPlot.Run] is called the buffers will be written
+		// to temporary files in [GnuPlotOpts.TempScriptDir] and the temporary
+		// files will be removed once gnuplot exits. The paths of the temporary files are
+		// determined when [NewGnuPlot] is called so the `{dat:#}` op can be
+		// used as normal.
+		InMemory bool
+		// Determines how the generated gnu plot code will be given to gnuplot.
+		// Defaults to [ScriptFile].
+		RunMode RunMode
+		// Positional arguments that will be passed to the gnu plot code when
+		// gnuplot is run. These arguments are available to the gnu plot code
+		// as `ARG1`, `ARG2`, etc. and can be referenced with the `{arg:#}` op.
+		// Arguments can only be used with the [Stdin] run mode if
+		// [GnuPlotOpts.TempScriptDir] is set.
+		Args []string
+		// When true [NewGnuPlot] will check that the gnuplot executable is
+		// installed before creating any files. See [CheckGnuPlot].
+		VerifyInstall bool
+		// When true the gplt and dat files will be appended to rather than
+		// truncated if they already exist. Ops will still resolve correctly
+		// because the file names are unchanged. This has no effect on files
+		// that are not created on disk, such as the gplt file when using the
+		// [Stdin] run mode or the dat files when [GnuPlotOpts.InMemory] is
+		// true. If [NewGnuPlot] fails no files will be removed when appending.
+		Append bool
+		// When true [NewGnuPlot] will call [GnuPlot.SetOutput] with an empty
+		// terminal so that the first cmds in the gnu plot code set the
+		// terminal and output file based on the out files extension.
+		AutoTerminal bool
+		// When true all methods that write data to the dat files will be safe
+		// to call from multiple goroutines. Each dat file is protected by its
+		// own lock so writes to different dat files will not block each other.
+		// Methods that write cmds, such as [GnuPlot.Cmds], as well as
+		// [GnuPlot.Run] and [GnuPlot.Close] are not safe to call concurrently.
+		Concurrent bool
+		// When true any value written through the csv writers that the csv
+		// writer would quote will be rejected with a [InvalidDataValueErr].
+		// This is any value that contains the [GnuPlotOpts.CsvSep] character,
+		// a double quote, or a newline, any value that starts with whitespace
+		// such as a space or tab, and the value `\.`. Without this option such
+		// values would be quoted by the csv writer, which gnuplot does not
+		// understand, resulting in columns being silently misread.
+		RejectSeparatorInValue bool
+		// When true [NewGnuPlot] will not return a [EmptyOutFileErr] when
+		// [GnuPlotOpts.OutFiles] is empty or contains an empty path. This is
+		// useful when the gnu plot code sets its own output. This is implied
+		// by [GnuPlotOpts.Persist].
+		AllowEmptyOutFile bool
+		// The extension, including the leading dot, that will be appended to
+		// [GnuPlotOpts.GpltFile]. Defaults to `.gplt` when empty.
+		GpltExt string
+		// The extension, including the leading dot, that will be appended to
+		// each of the [GnuPlotOpts.DatFiles]. Defaults to `.dat` when empty.
+		DatExt string
+		// When set, the gplt, dat, and out file paths will all be joined with
+		// this directory, making them relative to it rather than the current
+		// directory. The ops that resolve to paths will use the joined paths.
+		WorkDir string
+		// When true the parent directories of the gplt, dat, and out files
+		// will be created if they do not already exist.
+		MkdirParents bool
+		// When true [GnuPlot.RunBytes] will remove the out file after its
+		// contents have been read.
+		CleanupOut bool
+		// When true [GnuPlot.Run] will write a warning to the configured
+		// stderr for each dat file that is referenced by a `{dat:#}` op but
+		// has not had any data written to it. Data written with
+		// [GnuPlot.DataRaw] counts even though it is not counted as rows.
+		WarnEmptyDat bool
+		// When true [GnuPlot.Run] will return a [EmptyDatFileErr] rather than
+		// running gnuplot if any dat file that is referenced by a `{dat:#}` op
+		// has not had any data written to it. This takes precedence over
+		// [GnuPlotOpts.WarnEmptyDat].
+		ErrOnEmptyDat bool
+		// When true each dat file will be gzip compressed as it is written
+		// and `.gz` will be appended to its extension. The `{dat:#}` op will
+		// resolve to a `< gzip -dc` command so that gnuplot decompresses the
+		// data as it reads it, requiring gzip to be installed. This is useful
+		// for reducing the disk usage of very large data sets.
+		CompressDat bool
+		// The rules that [GnuPlot.Validate] will check the generated gnu plot
+		// code against. If nil [DefaultLintRules] will be used.
+		LintRules []LintRule
+		// When true the gplt and dat files will be removed once gnuplot exits
+		// successfully. The files are kept if gnuplot fails so they can be
+		// inspected. Any errors that occur while removing the files will be
+		// written to the configured stderr rather than causing
+		// [GnuPlot.Run] to fail.
+		CleanupAfterRun bool
+		// When true no dat files will be created. Instead the data for each
+		// dat file will be buffered in memory and written to the gnu plot code
+		// as a datablock named `$Data#`, where `#` is the index of the dat
+		// file, when [GnuPlot.Close] is called. The `{dat:#}` op will resolve
+		// to the name of the datablock rather than a path, making the gnu plot
+		// code self contained. Any data that would create a line that is
+		// exactly `EOD`, which gnuplot treats as the end of a datablock, will
+		// return a [InvalidDataValueErr] and binary rows cannot be written.
+		// This cannot be used with [GnuPlotOpts.InMemory] or
+		// [GnuPlotOpts.CompressDat].
+		InlineData bool
+		// Environment variables that will be added to the environment of the
+		// gnuplot process on top of the environment of the current process.
+		// If a variable is already set in the current process the value in
+		// this map takes precedence.
+		Env map[string]string
+		// When true gnuplot will be run with the `-persist` flag so that
+		// interactive windows, such as those created by the `wxt` or `qt`
+		// terminals, stay open after the gnu plot code finishes. Persist is
+		// meaningless when writing plots to files, so [NewGnuPlot] will return
+		// a [InvalidOptsErr] if it is combined with any non-empty out files.
+		// Setting Persist implies [GnuPlotOpts.AllowEmptyOutFile], so no out
+		// files need to be supplied.
+		Persist bool
+		// When true a json file will be written alongside each dat file that
+		// contains an array of all the rows written to the dat file, where each
+		// row is an array of strings. The json file will be named after the dat
+		// file with a `.json` extension and is always truncated, even if
+		// [GnuPlotOpts.Append] is true. Rows written with [GnuPlot.DataMatrix]
+		// are included but data written with [GnuPlot.DataRaw] or
+		// [GnuPlot.DataFromReader] is not parsed so it is not included. The
+		// json files are completed when [GnuPlot.Close] is called. The json
+		// files are always written to disk, even when [GnuPlotOpts.InMemory]
+		// or [GnuPlotOpts.InlineData] is true, so that the rows can still be
+		// inspected after gnuplot exits. If [GnuPlotOpts.DatExt] is `.json`
+		// the json files would replace the dat files, so [NewGnuPlot] will
+		// return a [InvalidOptsErr].
+		MirrorJSON bool
+		// When true [NewGnuPlot] will call [GnuPlot.SetDatafileSeparator] so
+		// that gnuplot reads the dat files using [GnuPlotOpts.CsvSep].
+		AutoDatafileSeparator bool
+		// The directory that transient files will be written to. This includes
+		// the temporary dat files used by [GnuPlotOpts.InMemory] and, when
+		// [GnuPlotOpts.Args] are used with the [Stdin] run mode, a temporary
+		// script that allows the args to be passed to gnuplot. Transient files
+		// are always removed once gnuplot exits. Defaults to [os.TempDir] when
+		// empty. If set, [NewGnuPlot] will return a [InvalidOptsErr] if the
+		// directory is not writable.
+		TempScriptDir string
+		// The maximum number of bytes that can be written to each dat file,
+		// counted before any compression is applied. Any write that would
+		// cause a dat file to exceed the limit will return a
+		// [DatFileTooLargeErr] and nothing more can be written to that dat
+		// file. To make sure the error is returned by the call that exceeds
+		// the limit each row is written directly to the dat file, rather than
+		// being buffered, when a limit is set. Exceeding the limit does not
+		// cause [GnuPlot.Close] to fail. A value of zero or less means there
+		// is no limit.
+		MaxDatBytes int64
+		// When set [GnuPlot.Cmds] will call this function with each cmd after
+		// its ops have been resolved, just before it is written to the gnu
+		// plot code. This is useful for seeing what gnu plot code the library
+		// generated. Nothing will be logged when nil.
+		DebugLog func(string)
+		// The token that NaN and infinite floats are replaced with when they
+		// are written to the dat files by the float writers, such as
+		// [GnuPlot.DataRowf], [GnuPlot.DataMatrix], and float64 values given
+		// to [GnuPlot.DataRowAny] or [GnuPlot.DataStructs]. Gnuplot cannot
+		// parse Go's `NaN` and `+Inf` strings, so use
+		// [GnuPlot.SetDatafileMissing] to make gnuplot treat the token as
+		// missing data and leave a gap in the plot. If left empty `?` will be
+		// used. If the token contains the [GnuPlotOpts.CsvSep] character, a
+		// double quote, or a newline a [InvalidOptsErr] will be returned.
+		MissingValue string
+		// When true `#!/usr/bin/env gnuplot` will be written as the first line
+		// of the gplt file and the gplt file will be made executable, so the
+		// generated script can be run directly later, such as with
+		// `./plot.gplt`. When [GnuPlotOpts.Append] is true the shebang is only
+		// written if the gplt file is empty. Setting this with the [Stdin] run
+		// mode will return a [InvalidOptsErr] because no gplt file is created.
+		Shebang bool
+		// When true the rows written to the dat files will end with `\r\n`
+		// instead of `\n`. This applies to the rows written by the csv
+		// writers as well as the lines written directly by methods such as
+		// [GnuPlot.DataComment], [GnuPlot.DataBreak], and
+		// [GnuPlot.DataMatrix]. Most gnuplot builds accept either line
+		// ending, but some misparse `\r\n`, for example by treating the `\r`
+		// as part of the last column, so only set this when the dat files need
+		// `\r\n` line endings, such as when they are also read by other tools.
+		UseCRLF bool
+		// Extra flags that will be passed to gnuplot when it is run, such as
+		// `-d` to ignore the user's gnuplot init file. The flags are placed
+		// after `-persist` and before `-c <gplt file>`. The `-c` flag is
+		// managed by the library, so including it will return a
+		// [InvalidOptsErr]. Note that when reading the script from stdin
+		// gnuplot will exit after any `-e` cmds unless `-` is also supplied.
+		ExtraArgs []string
+		// When true an html file that contains the svg inline will be written
+		// next to each out file once gnuplot exits successfully, so the plot
+		// can be embedded in a web page. The html file is named
+		// `<out file>.html`. Every out file must have a `.svg` extension,
+		// otherwise a [InvalidOptsErr] will be returned.
+		HTMLWrap bool
 	}
 )
 
+const (
+	// The generated gnu plot code will be written to the gplt file and
+	// gnuplot will be run with `gnuplot -c <gplt file>`.
+	ScriptFile RunMode = iota
+	// The generated gnu plot code will be kept in memory and fed to gnuplot
+	// over stdin. No gplt file will be created and the `{gplt}` op cannot be
+	// used.
+	Stdin
+)
+
+const shebang = "#!/usr/bin/env gnuplot\n"
+
 var (
 	// The regex that matches strings that need to be replaced in the supplied
 	// cmds. The exact contents of the string found by the regular expression
 	// will determine what it is replaced with.
 	OpRegex = regexp.MustCompile("\\${[^{]*}")
+	// The number of rows that [GnuPlot.DataRowsFromChan] will write before
+	// flushing the data file.
+	ChanFlushInterval = 100
 
 	InvalidOpErr       = errors.New("Invalid op")
+	UnterminatedOpErr  = errors.New("Unterminated op")
 	InvalidDatOpErr    = errors.New("Invalid dat op")
 	InvalidDatIndexErr = errors.New("Invalid data index")
+
+	UnsupportedDataTypeErr = errors.New("Unsupported data type")
+	InvalidDataHeaderErr   = errors.New("Invalid data header")
+	InvalidDataValueErr    = errors.New("Invalid data value")
+	InvalidBinaryRowErr    = errors.New("Invalid binary row")
+	DatFileTooLargeErr     = errors.New("Dat file too large")
+	RaggedMatrixErr        = errors.New("Ragged matrix")
+	InvalidMatrixLabelsErr = errors.New("Invalid matrix labels")
+	InvalidStructDataErr   = errors.New("Invalid struct data")
+	GnuPlotTimeoutErr      = errors.New("gnuplot timed out")
+	InvalidArgOpErr        = errors.New("Invalid arg op")
+	InvalidOptsErr         = errors.New("Invalid gnuplot opts")
+	EmptyOutFileErr        = errors.New("Empty out file")
+	InvalidOutOpErr        = errors.New("Invalid out op")
+	InvalidOutIndexErr     = errors.New("Invalid out index")
+	EmptyDatFileErr        = errors.New("Empty dat file")
+	InvalidTemplateErr     = errors.New("Invalid template")
+	FileErr                = errors.New("File error")
+	GnuPlotRunErr          = errors.New("gnuplot failed")
+	GnuPlotStartErr        = errors.New("Could not start gnuplot")
+	GnuPlotClosedErr       = errors.New("GnuPlot closed")
+	DatFlushErr            = errors.New("Could not flush dat file")
+	DataReadErr            = errors.New("Could not read data")
+
+	// A time whose elements all differ from the reference time used by Go
+	// layouts, used to check that a layout contains time elements.
+	layoutCheckTime = time.Date(1999, 12, 31, 23, 58, 59, 987654321, time.UTC)
 )
 
 // Creates a new [GnuPlot] struct with the supplied options. All data and gnu
 // plot code files will be created. The output file will be created by gnu plot
 // itself when the [GnuPlot.Run] method is called.
+//
+// If any of the files fail to be created then all files that were already
+// created will be closed and removed before the error is returned.
 func NewGnuPlot(opts GnuPlotOpts) (GnuPlot, error) {
-	gFile, err := os.Create(opts.GpltFile + ".gplt")
-	if err != nil {
-		return GnuPlot{}, err
+	return NewGnuPlotContext(context.Background(), opts)
+}
+
+// Creates a new [GnuPlot] struct in the same way as [NewGnuPlot] except that
+// the supplied context is checked before each file is created. If the context
+// is done all files that were already created will be closed and removed, as
+// if a file had failed to be created, and the contexts error will be returned.
+// The context is also used when [GnuPlotOpts.VerifyInstall] is true.
+func NewGnuPlotContext(
+	ctxt context.Context,
+	opts GnuPlotOpts,
+) (GnuPlot, error) {
+	if !opts.AllowEmptyOutFile && !opts.Persist {
+		if len(opts.OutFiles) == 0 {
+			return GnuPlot{}, sberr.Wrap(
+				EmptyOutFileErr,
+				"An out file must be supplied unless AllowEmptyOutFile is set",
+			)
+		}
+		for i, o := range opts.OutFiles {
+			if o == "" {
+				return GnuPlot{}, sberr.Wrap(
+					EmptyOutFileErr,
+					"Out file at index %d was empty and AllowEmptyOutFile is not set",
+					i,
+				)
+			}
+		}
+	}
+	if opts.RunMode == Stdin && len(opts.Args) > 0 && opts.TempScriptDir == "" {
+		return GnuPlot{}, sberr.Wrap(
+			InvalidOptsErr,
+			"Args cannot be used with the Stdin run mode without a TempScriptDir",
+		)
+	}
+	if opts.TempScriptDir != "" {
+		f, err := os.CreateTemp(opts.TempScriptDir, ".sbgnuplot-*")
+		if err != nil {
+			return GnuPlot{}, sberr.AppendError(
+				InvalidOptsErr,
+				sberr.InverseWrap(
+					err, "TempScriptDir is not writable: %s", opts.TempScriptDir,
+				),
+			)
+		}
+		f.Close()
+		os.Remove(f.Name())
+	}
+	if opts.Persist && slices.ContainsFunc(
+		opts.OutFiles, func(o string) bool { return o != "" },
+	) {
+		return GnuPlot{}, sberr.Wrap(
+			InvalidOptsErr, "Persist cannot be used with out files",
+		)
+	}
+	if opts.HTMLWrap && (len(opts.OutFiles) == 0 || slices.ContainsFunc(
+		opts.OutFiles, func(o string) bool {
+			return strings.ToLower(filepath.Ext(o)) != ".svg"
+		},
+	)) {
+		return GnuPlot{}, sberr.Wrap(
+			InvalidOptsErr,
+			"HTMLWrap requires all out files to be svg files: Got: %v",
+			opts.OutFiles,
+		)
+	}
+	if slices.Contains(opts.ExtraArgs, "-c") {
+		return GnuPlot{}, sberr.Wrap(
+			InvalidOptsErr,
+			"ExtraArgs cannot contain -c, it is set based on the RunMode",
+		)
+	}
+	if opts.Shebang && opts.RunMode == Stdin {
+		return GnuPlot{}, sberr.Wrap(
+			InvalidOptsErr, "Shebang cannot be used with the Stdin run mode",
+		)
+	}
+	if opts.InlineData && (opts.InMemory || opts.CompressDat) {
+		return GnuPlot{}, sberr.Wrap(
+			InvalidOptsErr,
+			"InlineData cannot be used with InMemory or CompressDat",
+		)
+	}
+
+	binary := opts.GnuPlotBinary
+	if binary == "" {
+		binary = "gnuplot"
+	}
+	if opts.VerifyInstall {
+		if _, err := checkGnuPlot(ctxt, binary); err != nil {
+			return GnuPlot{}, err
+		}
+	}
+
+	if opts.CsvSep == 0 {
+		opts.CsvSep = '\t'
+	}
+	if opts.CsvSep == '"' || opts.CsvSep == '\r' || opts.CsvSep == '\n' ||
+		!utf8.ValidRune(opts.CsvSep) || opts.CsvSep == utf8.RuneError {
+		return GnuPlot{}, sberr.Wrap(
+			InvalidOptsErr, "Invalid CsvSep: Got: %q", opts.CsvSep,
+		)
+	}
+	if opts.MissingValue == "" {
+		opts.MissingValue = "?"
+	}
+	if strings.ContainsAny(opts.MissingValue, string(opts.CsvSep)+"\"\r\n") {
+		return GnuPlot{}, sberr.Wrap(
+			InvalidOptsErr,
+			"MissingValue cannot contain the CsvSep, a double quote, or a newline: Got: %q",
+			opts.MissingValue,
+		)
+	}
+	if opts.GpltExt == "" {
+		opts.GpltExt = ".gplt"
+	}
+	if opts.DatExt == "" {
+		opts.DatExt = ".dat"
+	}
+	if opts.MirrorJSON && strings.EqualFold(opts.DatExt, ".json") &&
+		!opts.CompressDat && !opts.InMemory && !opts.InlineData {
+		return GnuPlot{}, sberr.Wrap(
+			InvalidOptsErr,
+			"MirrorJSON cannot be used with a DatExt of .json as the json files would replace the dat files",
+		)
+	}
+
+	gpltPath := workPath(opts, opts.GpltFile+opts.GpltExt)
+	outFiles := make([]string, len(opts.OutFiles))
+	for i, o := range opts.OutFiles {
+		outFiles[i] = workPath(opts, o)
+	}
+
+	if opts.MkdirParents {
+		paths := slices.Clone(outFiles)
+		if opts.RunMode != Stdin {
+			paths = append(paths, gpltPath)
+		}
+		for _, p := range paths {
+			if err := mkdirParents(p); err != nil {
+				return GnuPlot{}, err
+			}
+		}
+	}
+
+	var gpltName string
+	var gplt io.Writer
+	var gpltFile *os.File
+	createdFiles := []*os.File{}
+	checkCtxt := func() error {
+		if err := ctxt.Err(); err != nil {
+			cleanupFiles(createdFiles, !opts.Append)
+			return sberr.Wrap(err, "Cancelled while creating files")
+		}
+		return nil
+	}
+	if opts.RunMode == Stdin {
+		gplt = &bytes.Buffer{}
+	} else {
+		if err := checkCtxt(); err != nil {
+			return GnuPlot{}, err
+		}
+		gFile, err := createFile(gpltPath, opts.Append)
+		if err != nil {
+			return GnuPlot{}, err
+		}
+		createdFiles = append(createdFiles, gFile)
+		gpltName = gFile.Name()
+		gplt = gFile
+		if opts.Shebang {
+			if err := writeShebang(gFile); err != nil {
+				cleanupFiles(createdFiles, !opts.Append)
+				return GnuPlot{}, err
+			}
+		}
+		if opts.InlineData {
+			// The cmds are buffered so the datablocks can be written before
+			// them once the data is complete.
+			gpltFile = gFile
+			gplt = &bytes.Buffer{}
+		}
 	}
 
-	datFiles := make([]*os.File, len(opts.DatFiles))
+	datNames := make([]string, len(opts.DatFiles))
+	datWriters := make([]io.Writer, len(opts.DatFiles))
+	datCounters := make([]*countingWriter, len(opts.DatFiles))
 	csvWriters := make([]*csv.Writer, len(opts.DatFiles))
+	jsonMirrors := make([]*jsonMirror, len(opts.DatFiles))
 	for i := range len(opts.DatFiles) {
-		datFiles[i], err = os.Create(opts.DatFiles[i] + ".dat")
+		if err := checkCtxt(); err != nil {
+			return GnuPlot{}, err
+		}
+		var f *os.File
+		var err error
+		datNames[i], datWriters[i], f, err = newDat(opts, i, opts.DatFiles[i])
 		if err != nil {
+			cleanupFiles(createdFiles, !opts.Append)
 			return GnuPlot{}, err
 		}
-		csvWriters[i] = csv.NewWriter(datFiles[i])
+		if f != nil {
+			createdFiles = append(createdFiles, f)
+		}
+		if opts.MirrorJSON {
+			if err := checkCtxt(); err != nil {
+				return GnuPlot{}, err
+			}
+			jsonMirrors[i], err = newJSONMirror(opts, opts.DatFiles[i])
+			if err != nil {
+				cleanupFiles(createdFiles, !opts.Append)
+				return GnuPlot{}, err
+			}
+			createdFiles = append(createdFiles, jsonMirrors[i].f)
+		}
+		datCounters[i] = &countingWriter{
+			w: datWriters[i], max: opts.MaxDatBytes, name: datNames[i],
+		}
+		csvWriters[i] = csv.NewWriter(datCounters[i])
 		csvWriters[i].Comma = opts.CsvSep
+		csvWriters[i].UseCRLF = opts.UseCRLF
+	}
+
+	stdout := opts.Stdout
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	stderr := opts.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	floatPrecision := -1
+	if opts.FloatPrecision != nil {
+		floatPrecision = *opts.FloatPrecision
+	}
+
+	opts.DatFiles = slices.Clone(opts.DatFiles)
+	opts.OutFiles = slices.Clone(opts.OutFiles)
+	opts.Args = slices.Clone(opts.Args)
+	opts.LintRules = slices.Clone(opts.LintRules)
+	opts.ExtraArgs = slices.Clone(opts.ExtraArgs)
+	opts.Env = maps.Clone(opts.Env)
+	rv := GnuPlot{
+		opts:           opts,
+		outFiles:       outFiles,
+		binary:         binary,
+		floatPrecision: floatPrecision,
+		commentHeader:  opts.CommentHeader,
+		concurrent:     opts.Concurrent,
+		rejectSep:      opts.RejectSeparatorInValue,
+		inMemory:       opts.InMemory,
+		cleanupOut:     opts.CleanupOut,
+		warnEmptyDat:   opts.WarnEmptyDat,
+		errOnEmptyDat:  opts.ErrOnEmptyDat,
+		compressDat:    opts.CompressDat,
+		cleanupAfter:   opts.CleanupAfterRun,
+		inlineData:     opts.InlineData,
+		runMode:        opts.RunMode,
+		args:           append([]string{}, opts.Args...),
+		stdout:         stdout,
+		stderr:         stderr,
+		gpltName:       gpltName,
+		gplt:           gplt,
+		gpltFile:       gpltFile,
+		datNames:       datNames,
+		datWriters:     datWriters,
+		datCounters:    datCounters,
+		csvWriters:     csvWriters,
+		headers:        make([][]string, len(opts.DatFiles)),
+		rowCnts:        make([]int, len(opts.DatFiles)),
+		hasData:        make([]bool, len(opts.DatFiles)),
+		datRefs:        make([]bool, len(opts.DatFiles)),
+		datLocks:       make([]sync.Mutex, len(opts.DatFiles)),
+		jsonMirrors:    jsonMirrors,
+		binCols:        make([]int, len(opts.DatFiles)),
+	}
+	if opts.AutoTerminal {
+		if err := rv.SetOutput(""); err != nil {
+			cleanupFiles(createdFiles, !opts.Append)
+			return GnuPlot{}, err
+		}
+	}
+	if opts.AutoDatafileSeparator {
+		if err := rv.SetDatafileSeparator(); err != nil {
+			cleanupFiles(createdFiles, !opts.Append)
+			return GnuPlot{}, err
+		}
+	}
+	return rv, nil
+}
+
+// Creates a new [GnuPlot] struct in the same way as [NewGnuPlot] except that
+// the dat files will always be written as tab separated values, regardless of
+// the value of [GnuPlotOpts.CsvSep]. Tab separated values are parsed by gnuplot
+// without needing to set the datafile separator.
+func NewGnuPlotTSV(opts GnuPlotOpts) (GnuPlot, error) {
+	opts.CsvSep = '\t'
+	return NewGnuPlot(opts)
+}
+
+// Joins the supplied path with [GnuPlotOpts.WorkDir] if it is set.
+func workPath(opts GnuPlotOpts, p string) string {
+	if opts.WorkDir == "" || p == "" {
+		return p
+	}
+	return filepath.Join(opts.WorkDir, p)
+}
+
+func mkdirParents(p string) error {
+	if p == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fileErr(err, "Could not create parent directories of: %s", p)
+	}
+	return nil
+}
+
+// Creates the writer for the dat file with the supplied index and name. The
+// returned file will be nil if no file was created on disk.
+func newDat(
+	opts GnuPlotOpts,
+	idx int,
+	name string,
+) (string, io.Writer, *os.File, error) {
+	ext := opts.DatExt
+	if opts.CompressDat {
+		ext += ".gz"
+	}
+
+	var datName string
+	var w io.Writer
+	var f *os.File
+	if opts.InlineData {
+		datName = fmt.Sprintf("$Data%d", idx)
+		w = &bytes.Buffer{}
+	} else if opts.InMemory {
+		datName = tempDatName(opts.TempScriptDir, name, ext)
+		w = &bytes.Buffer{}
+	} else {
+		path := workPath(opts, name+ext)
+		if opts.MkdirParents {
+			if err := mkdirParents(path); err != nil {
+				return "", nil, nil, err
+			}
+		}
+		var err error
+		if f, err = createFile(path, opts.Append); err != nil {
+			return "", nil, nil, err
+		}
+		datName = f.Name()
+		w = f
+	}
+	if opts.CompressDat {
+		w = &gzipDat{Writer: gzip.NewWriter(w), under: w}
+	}
+	return datName, w, f, nil
+}
+
+func newJSONMirror(opts GnuPlotOpts, name string) (*jsonMirror, error) {
+	path := workPath(opts, name+".json")
+	if opts.MkdirParents {
+		if err := mkdirParents(path); err != nil {
+			return nil, err
+		}
+	}
+	f, err := createFile(path, false)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonMirror{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	if c.exceeded {
+		return 0, sberr.Wrap(
+			DatFileTooLargeErr,
+			"Dat file: %s Limit: %d bytes Limit was already exceeded",
+			c.name, c.max,
+		)
+	}
+	if c.max > 0 && c.n+int64(len(b)) > c.max {
+		c.exceeded = true
+		return 0, sberr.Wrap(
+			DatFileTooLargeErr,
+			"Dat file: %s Limit: %d bytes Got: %d bytes",
+			c.name, c.max, c.n+int64(len(b)),
+		)
+	}
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+func (j *jsonMirror) write(row []string) error {
+	sep := ",\n"
+	if j.rows == 0 {
+		sep = "[\n"
+	}
+	b, err := json.Marshal(row)
+	if err != nil {
+		return fileErr(err, "Could not encode json row: %s", j.f.Name())
+	}
+	j.w.WriteString(sep)
+	if _, err := j.w.Write(b); err != nil {
+		return fileErr(err, "Could not write json file: %s", j.f.Name())
+	}
+	j.rows++
+	return nil
+}
+
+// Writes the end of the json array and closes the json file.
+func (j *jsonMirror) close() error {
+	if j.rows == 0 {
+		j.w.WriteString("[")
+	}
+	j.w.WriteString("\n]\n")
+	err := j.w.Flush()
+	if err != nil {
+		err = fileErr(err, "Could not write json file: %s", j.f.Name())
+	}
+	if cErr := j.f.Close(); cErr != nil {
+		err = sberr.AppendError(err, fileErr(
+			cErr, "Could not close json file: %s", j.f.Name(),
+		))
+	}
+	return err
+}
+
+func createFile(path string, appendToFile bool) (*os.File, error) {
+	flags := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	if appendToFile {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0666)
+	if err != nil {
+		return nil, fileErr(err, "Could not create file: %s", path)
+	}
+	return f, nil
+}
+
+// Writes the shebang to the supplied gplt file if it is empty and makes the
+// file executable.
+func writeShebang(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return fileErr(err, "Could not stat gplt file: %s", f.Name())
+	}
+	if info.Size() == 0 {
+		if _, err := io.WriteString(f, shebang); err != nil {
+			return fileErr(err, "Could not write shebang: %s", f.Name())
+		}
+	}
+	if err := f.Chmod(0755); err != nil {
+		return fileErr(err, "Could not make gplt file executable: %s", f.Name())
+	}
+	return nil
+}
+
+// Wraps the supplied cause so that both [FileErr] and the cause can be found
+// with [errors.Is] and [errors.As].
+func fileErr(err error, fmtStr string, vals ...any) error {
+	return sberr.AppendError(FileErr, sberr.InverseWrap(err, fmtStr, vals...))
+}
+
+// Closes the gzip writer before closing the underlying writer, if it can be
+// closed, so that the gzip footer is written.
+func (g *gzipDat) Close() error {
+	err := g.Writer.Close()
+	if c, ok := g.under.(io.Closer); ok {
+		err = sberr.AppendError(err, c.Close())
+	}
+	return err
+}
+
+func tempDatName(dir string, name string, ext string) string {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(
+		dir,
+		fmt.Sprintf(
+			"%s-%s%s",
+			filepath.Base(name), strconv.FormatUint(rand.Uint64(), 36), ext,
+		),
+	)
+}
+
+func cleanupFiles(files []*os.File, remove bool) {
+	for _, f := range files {
+		f.Close()
+		if remove {
+			os.Remove(f.Name())
+		}
+	}
+}
+
+// Returns the path of the gnu plot code file, including the extension. When
+// using the [Stdin] run mode no gnu plot code file is created and an empty
+// string will be returned.
+func (g *GnuPlot) GpltPath() string {
+	return g.gpltName
+}
+
+// Creates a new data file with the supplied name in the same way as the files
+// in [GnuPlotOpts.DatFiles] are created by [NewGnuPlot] and returns its index,
+// which can be used with all methods and ops that reference a data file. The
+// data file will be recreated when [GnuPlot.Reset] is called. This method is
+// not safe to call concurrently with any other methods, even when
+// [GnuPlotOpts.Concurrent] is true. If [GnuPlot.Close] has already been called
+// a [GnuPlotClosedErr] will be returned.
+func (g *GnuPlot) AddDatFile(name string) (int, error) {
+	if g.closed {
+		return 0, sberr.Wrap(
+			GnuPlotClosedErr, "Cannot add a dat file after closing",
+		)
+	}
+	idx := len(g.datWriters)
+	datName, w, f, err := newDat(g.opts, idx, name)
+	if err != nil {
+		return 0, err
+	}
+	counter := &countingWriter{w: w, max: g.opts.MaxDatBytes, name: datName}
+	csvWriter := csv.NewWriter(counter)
+	csvWriter.Comma = g.opts.CsvSep
+	csvWriter.UseCRLF = g.opts.UseCRLF
+	var mirror *jsonMirror
+	if g.opts.MirrorJSON {
+		if mirror, err = newJSONMirror(g.opts, name); err != nil {
+			if f != nil {
+				cleanupFiles([]*os.File{f}, !g.opts.Append)
+			}
+			return 0, err
+		}
+	}
+
+	g.opts.DatFiles = append(g.opts.DatFiles, name)
+	g.datNames = append(g.datNames, datName)
+	g.datWriters = append(g.datWriters, w)
+	g.datCounters = append(g.datCounters, counter)
+	g.csvWriters = append(g.csvWriters, csvWriter)
+	g.headers = append(g.headers, nil)
+	g.rowCnts = append(g.rowCnts, 0)
+	g.hasData = append(g.hasData, false)
+	g.datRefs = append(g.datRefs, false)
+	g.datLocks = append(g.datLocks, sync.Mutex{})
+	g.jsonMirrors = append(g.jsonMirrors, mirror)
+	g.binCols = append(g.binCols, 0)
+	return idx, nil
+}
+
+// Returns the path of the data file at the supplied index, including the
+// extension. When [GnuPlotOpts.InlineData] is true the name of the datablock
+// will be returned instead. If the index is invalid a [InvalidDatIndexErr]
+// will be returned.
+func (g *GnuPlot) DatPath(i int) (string, error) {
+	if err := g.checkDatIdx(i); err != nil {
+		return "", err
+	}
+	return g.datNames[i], nil
+}
+
+// Returns the path of the out file at the supplied index. If the index is
+// invalid a [InvalidOutIndexErr] will be returned.
+func (g *GnuPlot) OutPath(i int) (string, error) {
+	if err := g.checkOutIdx(i); err != nil {
+		return "", err
 	}
+	return g.outFiles[i], nil
+}
 
-	return GnuPlot{
-		outFile:    opts.OutFile,
-		gpltFile:   gFile,
-		datFiles:   datFiles,
-		csvWriters: csvWriters,
-	}, nil
+// Returns the number of rows that have been written to the data file at the
+// supplied index. Headers, comments, and breaks are not counted as rows. Each
+// row of a matrix written with [GnuPlot.DataMatrix] and each line copied with
+// [GnuPlot.DataFromReader] are counted as a row. If the index is invalid a
+// [InvalidDatIndexErr] will be returned.
+func (g *GnuPlot) RowCount(file int) (int, error) {
+	if err := g.checkDatIdx(file); err != nil {
+		return 0, err
+	}
+	defer g.lockDat(file)()
+	return g.rowCnts[file], nil
 }
 
 // Writes cmds to the gnu plot code file. The cmds will be parsed for
 // operations. An operation will replace the given text with a specific value.
+// All ops that resolve to a path will wrap the path in single quotes, escaping
+// any single quotes that are in the path. If an op is missing its closing brace
+// a [UnterminatedOpErr] will be returned.
+//
+// To write a literal `${` without it being interpreted as an op, escape it by
+// adding an additional `$`. For example `$${dat:0}` will be written as the
+// literal text `${dat:0}`.
+//
 // Valid operations are as follows:
 //
-//   - {out}: Replaces `{out}` with the path of the out file
+//   - {out}: Replaces `{out}` with the path of the first out file. This is
+//     equivalent to `{out:0}`
+//   - {out:#}: Replaces `{out:#}` with the path of the out file at the index
+//     specified by `#`. If `#` is not a valid number, a negative number, or a
+//     number outside the range of the out file list an error will be returned
+//     and none of the supplied cmds will be added
+//   - {gplt}: Replaces `{gplt}` with the path of the gnu plot code file
 //   - {dat:#}: Replaces `{dat:#}` with the path of the data file at the index
 //     specified by `#`. If `#` is not a valid number, a negative number, or
 //     a number outside the range of the data file list an error will be
 //     returned and none of the supplied cmds will be added
+//   - {col:#:name}: Replaces `{col:#:name}` with the 1-based column number of
+//     the column called `name` in the data file at the index specified by `#`.
+//     The column names are taken from the header written with
+//     [GnuPlot.DataHeader]. If the data file has no header or the name is not
+//     in the header an error will be returned and none of the supplied cmds
+//     will be added
+//   - {arg:#}: Replaces `{arg:#}` with `ARG#`, the variable gnuplot uses to
+//     expose the positional argument at the index specified by `#`. The index
+//     is 1-based, with zero referring to the script name. If `#` is not a
+//     valid number, a negative number, or a number greater than the number of
+//     supplied [GnuPlotOpts.Args] an error will be returned and none of the
+//     supplied cmds will be added
+//   - {bin:#}: Replaces `{bin:#}` with the path of the data file at the index
+//     specified by `#` followed by the binary format of the rows written with
+//     [GnuPlot.DataBinaryRow], such as
+//     `'<dat file>' binary format='%float64%float64' endian=little`. If `#` is
+//     not a valid index or no binary rows were written to the data file an
+//     error will be returned and none of the supplied cmds will be added
+//   - {now}: Replaces `{now}` with the current time formatted as
+//     [time.RFC3339]. The time is not quoted. The current time is read once
+//     per call, so all `{now}` ops in the supplied cmds resolve to the same
+//     time
+//   - {now:layout}: Replaces `{now:layout}` with the current time formatted
+//     with the Go time layout `layout`, such as `{now:2006-01-02}`. If the
+//     layout is empty or contains no time elements an error will be returned
+//     and none of the supplied cmds will be added
 func (g *GnuPlot) Cmds(s ...string) error {
-	for _, iterS := range s {
-		if resolved, err := g.getResolvedCmd(iterS); err != nil {
+	if g.closed {
+		return sberr.Wrap(GnuPlotClosedErr, "Cannot write cmds after closing")
+	}
+	now := time.Now()
+	refs := slices.Clone(g.datRefs)
+	resolved := make([]string, len(s))
+	for i, iterS := range s {
+		var err error
+		if resolved[i], err = g.getResolvedCmd(iterS, now); err != nil {
+			g.datRefs = refs
 			return err
-		} else {
-			g.gpltFile.WriteString(resolved)
-			g.gpltFile.WriteString("\n")
 		}
 	}
+	for _, r := range resolved {
+		if g.opts.DebugLog != nil {
+			g.opts.DebugLog(r)
+		}
+		if _, err := io.WriteString(g.gplt, r+"\n"); err != nil {
+			return fileErr(err, "Could not write gplt file: %s", g.gpltName)
+		}
+		for _, l := range strings.Split(r, "\n") {
+			if isPlotCmd(strings.TrimSpace(l), "plot", "splot") {
+				g.plotted = true
+			}
+		}
+	}
+	return nil
+}
+
+// Executes the supplied [text/template] with the supplied data and then passes
+// the result to [GnuPlot.Cmds], meaning that the ops will be resolved after
+// the template has been executed. If the template cannot be parsed or
+// executed a [InvalidTemplateErr] will be returned and no cmds will be added.
+func (g *GnuPlot) CmdsTemplate(tmpl string, data any) error {
+	t, err := template.New("cmds").Parse(tmpl)
+	if err != nil {
+		return sberr.AppendError(
+			InvalidTemplateErr,
+			sberr.InverseWrap(err, "Could not parse cmds template"),
+		)
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return sberr.AppendError(
+			InvalidTemplateErr,
+			sberr.InverseWrap(err, "Could not execute cmds template"),
+		)
+	}
+	return g.Cmds(sb.String())
+}
+
+// Removes all of the gnu plot code that has been generated so far, including
+// any code that was already in the gplt file when [GnuPlotOpts.Append] is
+// true. The gplt file is truncated and rewound rather than recreated so more
+// cmds can be added with [GnuPlot.Cmds] afterwards. If [GnuPlotOpts.Shebang] is
+// true the shebang is written again after truncating. The dat files are not
+// modified.
+func (g *GnuPlot) ClearCmds() error {
+	if g.closed {
+		return sberr.Wrap(GnuPlotClosedErr, "Cannot clear cmds after closing")
+	}
+	switch w := g.gplt.(type) {
+	case *bytes.Buffer:
+		w.Reset()
+	case *os.File:
+		if err := w.Truncate(0); err != nil {
+			return fileErr(err, "Could not truncate gplt file: %s", g.gpltName)
+		}
+		if _, err := w.Seek(0, io.SeekStart); err != nil {
+			return fileErr(err, "Could not rewind gplt file: %s", g.gpltName)
+		}
+		if g.opts.Shebang {
+			if _, err := io.WriteString(w, shebang); err != nil {
+				return fileErr(err, "Could not write shebang: %s", g.gpltName)
+			}
+		}
+	}
+	clear(g.datRefs)
+	g.inMultiplot = false
+	g.plotted = false
 	return nil
 }
 
-func (g *GnuPlot) getResolvedCmd(cmd string) (string, error) {
+// Returns the gnu plot code that has been generated so far without executing
+// gnuplot. No files are closed so more cmds can be added and [GnuPlot.Run] can
+// still be called after calling this method.
+func (g *GnuPlot) Script() (string, error) {
+	if g.inlineData && !g.closed {
+		for i := range g.datWriters {
+			if err := g.flushDat(i); err != nil {
+				return "", err
+			}
+		}
+		// The gplt file holds anything written before the inline script, such
+		// as the shebang, until the inline script is written on close.
+		var existing []byte
+		if g.gpltFile != nil {
+			var err error
+			if existing, err = os.ReadFile(g.gpltName); err != nil {
+				return "", fileErr(
+					err, "Could not read gplt file: %s", g.gpltName,
+				)
+			}
+		}
+		return string(existing) + string(g.inlineScript()), nil
+	}
+	if buf, ok := g.gplt.(*bytes.Buffer); ok && g.gpltFile == nil {
+		return buf.String(), nil
+	}
+	b, err := os.ReadFile(g.gpltName)
+	if err != nil {
+		return "", fileErr(err, "Could not read gplt file: %s", g.gpltName)
+	}
+	return string(b), nil
+}
+
+func (g *GnuPlot) getResolvedCmd(
+	cmd string,
+	now time.Time,
+) (string, error) {
 	resolved := ""
 	prevIndex := 0
 	ops := OpRegex.FindAllIndex([]byte(cmd), -1)
+	if err := checkUnterminatedOps(cmd, ops); err != nil {
+		return resolved, err
+	}
 	if len(ops) == 0 {
-		return cmd, nil
+		return unescapeOps(cmd), nil
 	}
 
 	for _, op := range ops {
-		resolved += cmd[prevIndex:op[0]]
+		if isEscapedOp(cmd, op[0]) {
+			continue
+		}
+		resolved += unescapeOps(cmd[prevIndex:op[0]])
 
 		subStr := cmd[op[0]+2 : op[1]-1]
 		splitSubStr := strings.SplitN(subStr, ":", 2)
 		switch splitSubStr[0] {
 		case "dat":
-			if len(splitSubStr) != 2 {
+			idx, err := parseOpIdx(splitSubStr, InvalidDatOpErr)
+			if err != nil {
+				return resolved, err
+			}
+			if err := g.checkDatIdx(idx); err != nil {
+				return resolved, err
+			}
+			g.datRefs[idx] = true
+			resolved += g.datRef(idx)
+			prevIndex = op[1]
+		case "bin":
+			idx, err := parseOpIdx(splitSubStr, InvalidDatOpErr)
+			if err != nil {
+				return resolved, err
+			}
+			if err := g.checkDatIdx(idx); err != nil {
+				return resolved, err
+			}
+			if g.binCols[idx] == 0 {
 				return resolved, sberr.Wrap(
 					InvalidDatOpErr,
-					"Expected format: dat:<idx> Got: %s", subStr,
+					"No binary rows were written to dat file: %d", idx,
 				)
 			}
-			idx, err := strconv.Atoi(splitSubStr[1])
+			g.datRefs[idx] = true
+			resolved += fmt.Sprintf(
+				"%s binary format='%s' endian=little",
+				g.datRef(idx), strings.Repeat("%float64", g.binCols[idx]),
+			)
+			prevIndex = op[1]
+		case "col":
+			col, err := g.getColOp(subStr)
 			if err != nil {
-				return resolved, sberr.AppendError(
-					InvalidDatOpErr,
-					sberr.InverseWrap(
-						err,
-						"Index was not a valid number: Expected format: dat:<idx>",
-					),
-				)
+				return resolved, err
+			}
+			resolved += strconv.Itoa(col)
+			prevIndex = op[1]
+		case "arg":
+			idx, err := parseOpIdx(splitSubStr, InvalidArgOpErr)
+			if err != nil {
+				return resolved, err
 			}
-			if idx < 0 || idx >= len(g.datFiles) {
+			if idx < 0 || idx > len(g.args) {
 				return resolved, sberr.Wrap(
-					InvalidDatIndexErr,
-					"Dat file index out of range: Got: %d Allowed Range: [0, %d)",
-					idx, len(g.datFiles),
+					InvalidArgOpErr,
+					"Arg index out of range: Got: %d Allowed Range: [0, %d]",
+					idx, len(g.args),
 				)
 			}
-			resolved += fmt.Sprintf("'%s'", g.datFiles[idx].Name())
+			resolved += fmt.Sprintf("ARG%d", idx)
 			prevIndex = op[1]
 		case "out":
-			resolved += fmt.Sprintf("'%s'", g.outFile)
+			idx := 0
+			if len(splitSubStr) == 2 {
+				var err error
+				idx, err = parseOpIdx(splitSubStr, InvalidOutOpErr)
+				if err != nil {
+					return resolved, err
+				}
+			}
+			if err := g.checkOutIdx(idx); err != nil {
+				return resolved, err
+			}
+			resolved += quote(g.outFiles[idx])
+			prevIndex = op[1]
+		case "gplt":
+			if g.runMode == Stdin {
+				return resolved, sberr.Wrap(
+					InvalidOpErr,
+					"The gplt op cannot be used with the Stdin run mode",
+				)
+			}
+			resolved += quote(g.gpltName)
+			prevIndex = op[1]
+		case "now":
+			layout := time.RFC3339
+			if len(splitSubStr) == 2 {
+				layout = splitSubStr[1]
+				// A layout without any time elements formats to itself
+				if layout == "" || layoutCheckTime.Format(layout) == layout {
+					return resolved, sberr.Wrap(
+						InvalidOpErr,
+						"The now op layout contains no time elements: Got: %q",
+						layout,
+					)
+				}
+			}
+			resolved += now.Format(layout)
 			prevIndex = op[1]
 		default:
 			return resolved, sberr.Wrap(InvalidOpErr, "Got: %s", splitSubStr)
 		}
 	}
-	if len(ops[len(ops)-1]) == 2 {
-		resolved += cmd[ops[len(ops)-1][1]:]
-	}
+	resolved += unescapeOps(cmd[prevIndex:])
 	return resolved, nil
 }
 
-// Writes a data row to the data file specified by the `file` index. If the
-// index specified by `file` is invalid a [InvalidDatIndexErr] will be returned.
-//
-// To write an empty line call this method with a single empty string as the
-// data arguments.
-//
-// If no data arguments are provided no work will be done and no error will be
-// returned.
-func (g *GnuPlot) DataRow(file int, data ...string) error {
-	if len(data) <= 0 {
-		return nil
-	}
-	if file < 0 || file >= len(g.csvWriters) {
-		return sberr.Wrap(
-			InvalidDatIndexErr,
-			"Dat file index out of range: Got: %d Allowed Range: [0, %d)",
-			file, len(g.csvWriters),
-		)
+// Returns the text that gnuplot uses to reference the dat file at the supplied
+// index.
+func (g *GnuPlot) datRef(idx int) string {
+	if g.inlineData {
+		return g.datNames[idx]
+	} else if g.compressDat {
+		return quote("< gzip -dc " + shellQuote(g.datNames[idx]))
 	}
-	return g.csvWriters[file].Write(data)
+	return quote(g.datNames[idx])
+}
+
+// Returns true if the op starting at the supplied index is escaped, meaning
+// it is preceded by an additional `$`.
+func isEscapedOp(cmd string, start int) bool {
+	return start > 0 && cmd[start-1] == '$'
+}
+
+// Replaces all escaped ops, `$${`, with a literal `${`.
+func unescapeOps(s string) string {
+	return strings.ReplaceAll(s, "$${", "${")
+}
+
+// Escapes every `${` in the supplied string so that it is written literally by
+// [GnuPlot.Cmds].
+func escapeOps(s string) string {
+	return strings.ReplaceAll(s, "${", "$${")
+}
+
+// Checks that every `${` in the cmd is the start of an op that was matched by
+// [OpRegex]. Any `${` that is not the start of a matched op is missing its
+// closing brace.
+func checkUnterminatedOps(cmd string, ops [][]int) error {
+	opIdx := 0
+	for i := 0; i < len(cmd); {
+		start := strings.Index(cmd[i:], "${")
+		if start < 0 {
+			return nil
+		}
+		start += i
+		if isEscapedOp(cmd, start) {
+			i = start + 2
+			continue
+		}
+		for opIdx < len(ops) && ops[opIdx][0] < start {
+			opIdx++
+		}
+		if opIdx >= len(ops) || ops[opIdx][0] != start {
+			end := strings.Index(cmd[start+2:], "${")
+			if end < 0 {
+				end = len(cmd)
+			} else {
+				end += start + 2
+			}
+			return sberr.Wrap(
+				UnterminatedOpErr,
+				"Op is missing a closing brace: Got: %s", cmd[start:end],
+			)
+		}
+		i = start + 2
+	}
+	return nil
+}
+
+// Wraps the supplied string in single quotes, producing a gnuplot string
+// literal. Any single quotes in the string are escaped by doubling them, which
+// is how gnuplot escapes single quotes in single quoted strings. No other
+// characters need to be escaped because gnuplot does not process escape
+// sequences in single quoted strings.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// Quotes the supplied string for the shell that gnuplot runs `<` cmds with.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Parses the index of ops with the format `<op>:<idx>`. The supplied op error
+// will be used for any errors that are returned.
+func parseOpIdx(splitSubStr []string, opErr error) (int, error) {
+	if len(splitSubStr) != 2 {
+		return 0, sberr.Wrap(
+			opErr,
+			"Expected format: %s:<idx> Got: %s",
+			splitSubStr[0], strings.Join(splitSubStr, ":"),
+		)
+	}
+	idx, err := strconv.Atoi(splitSubStr[1])
+	if err != nil {
+		return 0, sberr.AppendError(
+			opErr,
+			sberr.InverseWrap(
+				err,
+				"Index was not a valid number: Expected format: %s:<idx>",
+				splitSubStr[0],
+			),
+		)
+	}
+	return idx, nil
+}
+
+func (g *GnuPlot) getColOp(subStr string) (int, error) {
+	splitSubStr := strings.SplitN(subStr, ":", 3)
+	if len(splitSubStr) != 3 {
+		return 0, sberr.Wrap(
+			InvalidDatOpErr,
+			"Expected format: col:<idx>:<name> Got: %s", subStr,
+		)
+	}
+	idx, err := strconv.Atoi(splitSubStr[1])
+	if err != nil {
+		return 0, sberr.AppendError(
+			InvalidDatOpErr,
+			sberr.InverseWrap(
+				err,
+				"Index was not a valid number: Expected format: col:<idx>:<name>",
+			),
+		)
+	}
+	if err := g.checkDatIdx(idx); err != nil {
+		return 0, err
+	}
+	if g.headers[idx] == nil {
+		return 0, sberr.Wrap(
+			InvalidDatOpErr,
+			"Dat file does not have a header: Dat file: %d", idx,
+		)
+	}
+	for i, name := range g.headers[idx] {
+		if name == splitSubStr[2] {
+			return i + 1, nil
+		}
+	}
+	return 0, sberr.Wrap(
+		InvalidDatOpErr,
+		"Column was not in the dat files header: Dat file: %d Got: %s Header: %v",
+		idx, splitSubStr[2], g.headers[idx],
+	)
+}
+
+// Writes a data row to the data file specified by the `file` index. If the
+// index specified by `file` is invalid a [InvalidDatIndexErr] will be returned.
+//
+// To write an empty line use [GnuPlot.DataBreak].
+//
+// If no data arguments are provided no work will be done and no error will be
+// returned.
+func (g *GnuPlot) DataRow(file int, data ...string) error {
+	if len(data) <= 0 {
+		return nil
+	}
+	if err := g.checkDatWrite(file); err != nil {
+		return err
+	}
+	return g.writeRow(file, data)
+}
+
+// Writes a data row in the same way as [GnuPlot.DataRow] and returns the number
+// of bytes that the row added to the data file, including the separators and
+// the trailing newline. The bytes are counted before any compression is
+// applied. The data file is flushed before and after the row is written so
+// that the bytes can be attributed to the row, making this slower than
+// [GnuPlot.DataRow] when writing many rows.
+func (g *GnuPlot) DataRowN(file int, data ...string) (int, error) {
+	if len(data) <= 0 {
+		return 0, nil
+	}
+	if err := g.checkDatWrite(file); err != nil {
+		return 0, err
+	}
+	if err := g.checkDataValues(file, data); err != nil {
+		return 0, err
+	}
+
+	defer g.lockDat(file)()
+	g.csvWriters[file].Flush()
+	if err := g.csvWriters[file].Error(); err != nil {
+		return 0, fileErr(
+			err, "Could not flush dat file: %s", g.datNames[file],
+		)
+	}
+	before := g.datCounters[file].n
+	if err := g.writeRowLocked(file, data); err != nil {
+		return 0, err
+	}
+	g.csvWriters[file].Flush()
+	if err := g.csvWriters[file].Error(); err != nil {
+		return 0, fileErr(
+			err, "Could not flush dat file: %s", g.datNames[file],
+		)
+	}
+	return int(g.datCounters[file].n - before), nil
+}
+
+// Writes all of the supplied rows to the data file specified by the `file`
+// index. The index is only checked once, making this more efficient than
+// calling [GnuPlot.DataRow] repeatedly. If the index specified by `file` is
+// invalid a [InvalidDatIndexErr] will be returned.
+//
+// Writing stops at the first row that fails to be written and the returned
+// error will contain the index of that row. Any rows before the failed row
+// will have already been written. Empty rows are skipped.
+func (g *GnuPlot) DataRows(file int, rows [][]string) error {
+	if err := g.checkDatWrite(file); err != nil {
+		return err
+	}
+	for i, row := range rows {
+		if len(row) <= 0 {
+			continue
+		}
+		if err := g.writeRow(file, row); err != nil {
+			return sberr.Wrap(err, "Failed to write row: %d", i)
+		}
+	}
+	return nil
+}
+
+// Writes the row to the dat file at the supplied index. The index is expected
+// to have already been checked.
+func (g *GnuPlot) writeRow(idx int, data []string) error {
+	if err := g.checkDataValues(idx, data); err != nil {
+		return err
+	}
+	defer g.lockDat(idx)()
+	return g.writeRowLocked(idx, data)
+}
+
+// Writes the row to the csv writer and json mirror of the dat file. The caller
+// is expected to hold the dat files lock.
+func (g *GnuPlot) writeRowLocked(idx int, data []string) error {
+	if err := g.writeCsvLocked(idx, data); err != nil {
+		return err
+	}
+	if g.jsonMirrors[idx] != nil {
+		if err := g.jsonMirrors[idx].write(data); err != nil {
+			return err
+		}
+	}
+	g.rowCnts[idx]++
+	g.hasData[idx] = true
+	return nil
+}
+
+// Writes the record to the dat file at the supplied index using its csv writer.
+// When [GnuPlotOpts.MaxDatBytes] is set the record is encoded separately and
+// written directly so that a record that exceeds the limit never reaches the
+// csv writer, which would otherwise keep the error and fail every later flush.
+// The same is done when [GnuPlotOpts.InlineData] is true so that the encoded
+// record can be checked for lines that would end the datablock. The caller is
+// expected to hold the dat files lock.
+func (g *GnuPlot) writeCsvLocked(idx int, record []string) error {
+	if g.datCounters[idx].max <= 0 && !g.inlineData {
+		if err := g.csvWriters[idx].Write(record); err != nil {
+			return fileErr(err, "Could not write dat file: %s", g.datNames[idx])
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Comma = g.csvWriters[idx].Comma
+	w.UseCRLF = g.csvWriters[idx].UseCRLF
+	if err := w.Write(record); err != nil {
+		return fileErr(err, "Could not write dat file: %s", g.datNames[idx])
+	}
+	w.Flush()
+	return g.writeDatRaw(idx, buf.Bytes())
+}
+
+// Writes each row received from the `rows` channel to the data file specified
+// by the `file` index until either the channel is closed or the context is
+// cancelled. The data file will be flushed every [ChanFlushInterval] rows as
+// well as when this method returns. If the index specified by `file` is invalid
+// a [InvalidDatIndexErr] will be returned.
+//
+// Any error that occurs while writing a row will be returned immediately. If
+// the context is cancelled the contexts error will be returned. If the final
+// flush fails its error will be returned, including when the channel was
+// closed without any other error occurring.
+func (g *GnuPlot) DataRowsFromChan(
+	ctxt context.Context,
+	file int,
+	rows <-chan []string,
+) error {
+	if err := g.checkDatWrite(file); err != nil {
+		return err
+	}
+
+	cntr := 0
+	for {
+		select {
+		case <-ctxt.Done():
+			return sberr.AppendError(ctxt.Err(), g.flushDat(file))
+		case row, ok := <-rows:
+			if !ok {
+				return g.flushDat(file)
+			}
+			if err := g.DataRow(file, row...); err != nil {
+				return sberr.AppendError(err, g.flushDat(file))
+			}
+			cntr++
+			if cntr%ChanFlushInterval == 0 {
+				if err := g.flushDat(file); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// Writes a header row containing the supplied column names to the data file
+// specified by the `file` index. If [GnuPlotOpts.CommentHeader] is true the
+// header row will be prefixed with `#`. If the index specified by `file` is
+// invalid a [InvalidDatIndexErr] will be returned.
+//
+// The header must be written before any data rows are written to the data file
+// and can only be written once. If either of these conditions is not met a
+// [InvalidDataHeaderErr] will be returned.
+func (g *GnuPlot) DataHeader(file int, columns ...string) error {
+	if err := g.checkDatWrite(file); err != nil {
+		return err
+	}
+	defer g.lockDat(file)()
+	if g.headers[file] != nil {
+		return sberr.Wrap(
+			InvalidDataHeaderErr,
+			"A header was already written to dat file: %d", file,
+		)
+	}
+	if g.rowCnts[file] > 0 {
+		return sberr.Wrap(
+			InvalidDataHeaderErr,
+			"Data rows were already written to dat file: %d Rows: %d",
+			file, g.rowCnts[file],
+		)
+	}
+
+	if err := g.checkDataValues(file, columns); err != nil {
+		return err
+	}
+
+	if g.commentHeader {
+		g.csvWriters[file].Flush()
+		_, err := io.WriteString(
+			g.datCounters[file],
+			"# "+
+				strings.Join(columns, string(g.csvWriters[file].Comma))+
+				g.newline(),
+		)
+		if err != nil {
+			return fileErr(
+				err, "Could not write dat file: %s", g.datNames[file],
+			)
+		}
+	} else if err := g.writeCsvLocked(file, columns); err != nil {
+		return err
+	}
+	g.headers[file] = append([]string{}, columns...)
+	return nil
+}
+
+// Writes a data row of floats to the data file specified by the `file` index.
+// Each float will be formatted using the precision specified by
+// [GnuPlotOpts.FloatPrecision]. If the index specified by `file` is invalid a
+// [InvalidDatIndexErr] will be returned.
+//
+// If no data arguments are provided no work will be done and no error will be
+// returned.
+func (g *GnuPlot) DataRowf(file int, data ...float64) error {
+	if len(data) <= 0 {
+		return nil
+	}
+	strData := make([]string, len(data))
+	for i, v := range data {
+		strData[i] = g.formatFloat(v)
+	}
+	return g.DataRow(file, strData...)
+}
+
+// Writes a data row of mixed typed values to the data file specified by the
+// `file` index. Each value will be formatted as follows:
+//
+//   - int, int64: base 10 representation
+//   - float64: the shortest representation that exactly represents the value
+//   - string: the string itself
+//   - bool: `1` for true and `0` for false
+//   - [fmt.Stringer]: the value returned by the String method
+//
+// If any value is not one of the above types a [UnsupportedDataTypeErr] will
+// be returned and no data will be written. If the index specified by `file` is
+// invalid a [InvalidDatIndexErr] will be returned.
+//
+// If no data arguments are provided no work will be done and no error will be
+// returned.
+func (g *GnuPlot) DataRowAny(file int, data ...any) error {
+	if len(data) <= 0 {
+		return nil
+	}
+	strData := make([]string, len(data))
+	for i, v := range data {
+		var err error
+		if strData[i], err = g.formatAny(v); err != nil {
+			return sberr.Wrap(err, "Data index: %d", i)
+		}
+	}
+	return g.DataRow(file, strData...)
+}
+
+func (g *GnuPlot) formatAny(v any) (string, error) {
+	switch iterV := v.(type) {
+	case int:
+		return strconv.Itoa(iterV), nil
+	case int64:
+		return strconv.FormatInt(iterV, 10), nil
+	case float64:
+		if math.IsNaN(iterV) || math.IsInf(iterV, 0) {
+			return g.opts.MissingValue, nil
+		}
+		return strconv.FormatFloat(iterV, 'g', -1, 64), nil
+	case string:
+		return iterV, nil
+	case bool:
+		if iterV {
+			return "1", nil
+		}
+		return "0", nil
+	case fmt.Stringer:
+		return iterV.String(), nil
+	default:
+		return "", sberr.Wrap(UnsupportedDataTypeErr, "Got: %T", v)
+	}
+}
+
+// Writes a data row for each element of the supplied slice of structs to the
+// data file specified by the `file` index. Each row will contain the values of
+// the named fields, in the order they were supplied. The elements of the slice
+// may either be structs or pointers to structs. The field values are formatted
+// the same way as [GnuPlot.DataRowAny], so a [UnsupportedDataTypeErr] will be
+// returned if a field has an unsupported type.
+//
+// A [InvalidStructDataErr] will be returned if `data` is not a slice of
+// structs, if no fields were supplied, or if any of the fields are not
+// exported fields of the struct. If the index specified by `file` is invalid a
+// [InvalidDatIndexErr] will be returned.
+func (g *GnuPlot) DataStructs(file int, data any, fields ...string) error {
+	if err := g.checkDatWrite(file); err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return sberr.Wrap(InvalidStructDataErr, "No fields were supplied")
+	}
+
+	val := reflect.ValueOf(data)
+	if val.Kind() != reflect.Slice {
+		return sberr.Wrap(
+			InvalidStructDataErr, "Expected a slice of structs: Got: %T", data,
+		)
+	}
+	elemType := val.Type().Elem()
+	if elemType.Kind() == reflect.Pointer {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return sberr.Wrap(
+			InvalidStructDataErr, "Expected a slice of structs: Got: %T", data,
+		)
+	}
+	for _, f := range fields {
+		sf, ok := elemType.FieldByName(f)
+		if !ok || !sf.IsExported() {
+			return sberr.Wrap(
+				InvalidStructDataErr,
+				"Field is not an exported field of %s: Got: %s", elemType, f,
+			)
+		}
+	}
+
+	for i := range val.Len() {
+		elem := reflect.Indirect(val.Index(i))
+		if !elem.IsValid() {
+			return sberr.Wrap(
+				InvalidStructDataErr, "Element %d was a nil pointer", i,
+			)
+		}
+		row := make([]string, len(fields))
+		for j, f := range fields {
+			var err error
+			row[j], err = g.formatAny(elem.FieldByName(f).Interface())
+			if err != nil {
+				return sberr.Wrap(err, "Element: %d Field: %s", i, f)
+			}
+		}
+		if err := g.writeRow(file, row); err != nil {
+			return sberr.Wrap(err, "Failed to write element: %d", i)
+		}
+	}
+	return nil
+}
+
+// Writes a comment line to the data file specified by the `file` index. The
+// comment will be written as `# <text>` directly to the data file, bypassing
+// the csv writer so the text will not be quoted. If the index specified by
+// `file` is invalid a [InvalidDatIndexErr] will be returned. If the text
+// contains a `\r` or `\n` a [InvalidDataValueErr] will be returned, as the
+// lines after the first would otherwise be read as data rows.
+func (g *GnuPlot) DataComment(file int, text string) error {
+	if err := g.checkDatWrite(file); err != nil {
+		return err
+	}
+	if strings.ContainsAny(text, "\r\n") {
+		return sberr.Wrap(
+			InvalidDataValueErr,
+			"Comment cannot contain a line break: Got: %q", text,
+		)
+	}
+	defer g.lockDat(file)()
+	return g.writeDatRaw(file, []byte("# "+text+g.newline()))
+}
+
+// Writes a blank line to the data file specified by the `file` index. The
+// blank line is written directly to the data file, bypassing the csv writer, so
+// it is guaranteed to be truly empty. gnuplot uses blank lines to separate
+// blocks of data, which can then be selected with the `index` keyword. If the
+// index specified by `file` is invalid a [InvalidDatIndexErr] will be returned.
+func (g *GnuPlot) DataBreak(file int) error {
+	if err := g.checkDatWrite(file); err != nil {
+		return err
+	}
+	defer g.lockDat(file)()
+	return g.writeDatRaw(file, []byte(g.newline()))
+}
+
+// Writes a matrix of floats to the data file specified by the `file` index in
+// the format gnuplot expects when using the `matrix` keyword, as is typically
+// done for heatmaps and surface plots. Each row of the matrix is written as a
+// single line of values separated by [GnuPlotOpts.CsvSep], so the same
+// `set datafile separator` cmd, such as the one written by
+// [GnuPlot.SetDatafileSeparator], works for both matrix and csv rows. Each
+// float will be formatted using
+// the precision specified by [GnuPlotOpts.FloatPrecision]. Use
+// [GnuPlot.DataBreak] to separate multiple matrices in a single data file.
+//
+// All rows of the matrix must have the same length, otherwise a
+// [RaggedMatrixErr] will be returned and no data will be written. If the index
+// specified by `file` is invalid a [InvalidDatIndexErr] will be returned.
+func (g *GnuPlot) DataMatrix(file int, m [][]float64) error {
+	if err := g.checkDatWrite(file); err != nil {
+		return err
+	}
+	var sb strings.Builder
+	for i, row := range m {
+		if len(row) != len(m[0]) {
+			return sberr.Wrap(
+				RaggedMatrixErr,
+				"Row %d has length %d, expected length %d",
+				i, len(row), len(m[0]),
+			)
+		}
+		for j, v := range row {
+			if j > 0 {
+				sb.WriteRune(g.opts.CsvSep)
+			}
+			sb.WriteString(g.formatFloat(v))
+		}
+		sb.WriteString(g.newline())
+	}
+
+	defer g.lockDat(file)()
+	if err := g.writeDatRaw(file, []byte(sb.String())); err != nil {
+		return err
+	}
+	if g.jsonMirrors[file] != nil {
+		for _, row := range m {
+			strRow := make([]string, len(row))
+			for j, v := range row {
+				strRow[j] = g.formatFloat(v)
+			}
+			if err := g.jsonMirrors[file].write(strRow); err != nil {
+				return err
+			}
+		}
+	}
+	g.rowCnts[file] += len(m)
+	if len(m) > 0 {
+		g.hasData[file] = true
+	}
+	return nil
+}
+
+// Writes a matrix of floats with row and column labels to the data file
+// specified by the `file` index, as is typically done for annotated heatmaps
+// such as confusion matrices. The first line contains the column names and
+// each following line starts with its row name, with all labels double quoted
+// and an empty `""` label in the corner. The values are separated by
+// [GnuPlotOpts.CsvSep], shown here as a space:
+//
+//	"" "<col 0>" "<col 1>"
+//	"<row 0>" <value> <value>
+//
+// This is the format gnuplot expects when using
+// `matrix rowheaders columnheaders`, such as:
+//
+//	plot '<dat file>' matrix rowheaders columnheaders using 1:2:3 with image
+//
+// Each float will be formatted in the same way as [GnuPlot.DataMatrix]. All
+// rows of the matrix must have the same length, otherwise a [RaggedMatrixErr]
+// will be returned. If the number of row names does not match the number of
+// rows, the number of column names does not match the number of columns, or
+// any label contains a double quote or a newline a [InvalidMatrixLabelsErr]
+// will be returned. No data will be written if an error is returned. If the
+// index specified by `file` is invalid a [InvalidDatIndexErr] will be
+// returned.
+func (g *GnuPlot) DataMatrixLabeled(
+	file int,
+	rowNames []string,
+	colNames []string,
+	m [][]float64,
+) error {
+	if err := g.checkDatWrite(file); err != nil {
+		return err
+	}
+	if len(rowNames) != len(m) {
+		return sberr.Wrap(
+			InvalidMatrixLabelsErr,
+			"Number of row names does not match number of rows: Got: %d Expected: %d",
+			len(rowNames), len(m),
+		)
+	}
+	for i, row := range m {
+		if len(row) != len(m[0]) {
+			return sberr.Wrap(
+				RaggedMatrixErr,
+				"Row %d has length %d, expected length %d",
+				i, len(row), len(m[0]),
+			)
+		}
+	}
+	if len(m) > 0 && len(colNames) != len(m[0]) {
+		return sberr.Wrap(
+			InvalidMatrixLabelsErr,
+			"Number of column names does not match number of columns: Got: %d Expected: %d",
+			len(colNames), len(m[0]),
+		)
+	}
+	for _, l := range slices.Concat(rowNames, colNames) {
+		if strings.ContainsAny(l, "\"\r\n") {
+			return sberr.Wrap(
+				InvalidMatrixLabelsErr,
+				"Label contains a double quote or a newline: Got: %q", l,
+			)
+		}
+	}
+
+	grid := make([][]string, len(m)+1)
+	grid[0] = append([]string{`""`}, make([]string, len(colNames))...)
+	for j, c := range colNames {
+		grid[0][j+1] = `"` + c + `"`
+	}
+	for i, row := range m {
+		grid[i+1] = make([]string, len(row)+1)
+		grid[i+1][0] = `"` + rowNames[i] + `"`
+		for j, v := range row {
+			grid[i+1][j+1] = g.formatFloat(v)
+		}
+	}
+	var sb strings.Builder
+	for _, row := range grid {
+		sb.WriteString(strings.Join(row, string(g.opts.CsvSep)))
+		sb.WriteString(g.newline())
+	}
+
+	defer g.lockDat(file)()
+	if err := g.writeDatRaw(file, []byte(sb.String())); err != nil {
+		return err
+	}
+	if g.jsonMirrors[file] != nil {
+		for _, row := range grid {
+			if err := g.jsonMirrors[file].write(row); err != nil {
+				return err
+			}
+		}
+	}
+	g.rowCnts[file] += len(m)
+	g.hasData[file] = true
+	return nil
+}
+
+// Copies the contents of the supplied reader directly to the data file at the
+// supplied index without parsing it. The contents are expected to already be
+// in a format gnuplot understands, such as csv data that uses
+// [GnuPlotOpts.CsvSep], so no validation is performed on it. A trailing newline
+// will be added if the contents do not end with one so that later rows are not
+// joined to the last copied row. Use [GnuPlot.DataFromCsvReader] if the
+// contents use a different separator. If the index is invalid a
+// [InvalidDatIndexErr] will be returned and if the reader returns an error a
+// [DataReadErr] will be returned.
+func (g *GnuPlot) DataFromReader(file int, r io.Reader) error {
+	if err := g.checkDatWrite(file); err != nil {
+		return err
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return sberr.AppendError(
+			DataReadErr, sberr.InverseWrap(err, "Dat file: %d", file),
+		)
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	if b[len(b)-1] != '\n' {
+		b = append(b, g.newline()...)
+	}
+
+	defer g.lockDat(file)()
+	if err := g.writeDatRaw(file, b); err != nil {
+		return err
+	}
+	g.rowCnts[file] += bytes.Count(b, []byte{'\n'})
+	if len(b) > 0 {
+		g.hasData[file] = true
+	}
+	return nil
+}
+
+// Reads the csv data from the supplied reader using the supplied separator and
+// writes each record to the data file at the supplied index as a row,
+// transcoding the data to use [GnuPlotOpts.CsvSep]. Records are allowed to have
+// differing numbers of fields. If the index is invalid a [InvalidDatIndexErr]
+// will be returned. If the data cannot be parsed a [DataReadErr] that wraps the
+// error from the [csv.Reader] will be returned, and any records before the
+// invalid record will have already been written.
+func (g *GnuPlot) DataFromCsvReader(file int, r io.Reader, sep rune) error {
+	if err := g.checkDatWrite(file); err != nil {
+		return err
+	}
+	reader := csv.NewReader(r)
+	reader.Comma = sep
+	reader.FieldsPerRecord = -1
+	for i := 0; ; i++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return sberr.AppendError(
+				DataReadErr,
+				sberr.InverseWrap(err, "Dat file: %d Csv record: %d", file, i),
+			)
+		}
+		if err := g.writeRow(file, record); err != nil {
+			return sberr.Wrap(err, "Failed to write row: %d", i)
+		}
+	}
+}
+
+// Writes the supplied bytes directly to the data file at the supplied index,
+// bypassing the csv writer. Any rows that are buffered by the csv writer are
+// flushed first so the order of the written data is preserved. The bytes are
+// written as is, so no newline is added and no validation is performed. The
+// bytes are not counted by [GnuPlot.RowCount]. If the index is invalid a
+// [InvalidDatIndexErr] will be returned.
+func (g *GnuPlot) DataRaw(file int, b []byte) error {
+	if err := g.checkDatWrite(file); err != nil {
+		return err
+	}
+	defer g.lockDat(file)()
+	if err := g.writeDatRaw(file, b); err != nil {
+		return err
+	}
+	if len(b) > 0 {
+		g.hasData[file] = true
+	}
+	return nil
+}
+
+// Writes the supplied values to the data file at the supplied index as a row of
+// little endian float64 values. Gnuplot parses binary data much faster than
+// text data, making this useful for very large data sets. The binary data
+// should be referenced with the `{bin:#}` op rather than the `{dat:#}` op so
+// that gnuplot is given the binary format. All binary rows written to a data
+// file must have the same number of values and should not be mixed with text
+// rows. If no values are provided no work will be done and no error will be
+// returned.
+//
+// If the index is invalid a [InvalidDatIndexErr] will be returned. If the
+// number of values differs from the previous binary rows, or
+// [GnuPlotOpts.InlineData] is true, a [InvalidBinaryRowErr] will be returned.
+func (g *GnuPlot) DataBinaryRow(file int, vals ...float64) error {
+	if len(vals) == 0 {
+		return nil
+	}
+	if err := g.checkDatWrite(file); err != nil {
+		return err
+	}
+	if g.inlineData {
+		return sberr.Wrap(
+			InvalidBinaryRowErr, "Binary rows cannot be written as inline data",
+		)
+	}
+
+	defer g.lockDat(file)()
+	if g.binCols[file] != 0 && g.binCols[file] != len(vals) {
+		return sberr.Wrap(
+			InvalidBinaryRowErr,
+			"Expected %d values: Got: %d", g.binCols[file], len(vals),
+		)
+	}
+	b := make([]byte, 0, 8*len(vals))
+	for _, v := range vals {
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+	}
+	if err := g.writeDatRaw(file, b); err != nil {
+		return err
+	}
+	g.binCols[file] = len(vals)
+	g.rowCnts[file]++
+	g.hasData[file] = true
+	return nil
+}
+
+// Reads back the rows that have been written to the data file at the supplied
+// index so far, flushing any buffered data first. The data is parsed as csv
+// data using [GnuPlotOpts.CsvSep], skipping empty lines and lines that start
+// with `#`, such as headers written when [GnuPlotOpts.CommentHeader] is true.
+// Rows are allowed to have differing numbers of fields. This works for all of
+// the ways that a data file can be stored, including in memory, compressed,
+// and inline data. If the index is invalid a [InvalidDatIndexErr] will be
+// returned.
+func (g *GnuPlot) ReadDataFile(file int) ([][]string, error) {
+	if err := g.checkDatIdx(file); err != nil {
+		return nil, err
+	}
+	b, err := g.datBytes(file)
+	if err != nil {
+		return nil, err
+	}
+	reader := csv.NewReader(bytes.NewReader(b))
+	reader.Comma = g.csvWriters[file].Comma
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	rv, err := reader.ReadAll()
+	if err != nil {
+		return nil, fileErr(
+			err, "Could not parse dat file: %s", g.datNames[file],
+		)
+	}
+	return rv, nil
+}
+
+// Returns the uncompressed contents of the dat file at the supplied index.
+func (g *GnuPlot) datBytes(idx int) ([]byte, error) {
+	defer g.lockDat(idx)()
+	w := g.datWriters[idx]
+	gz, compressed := w.(*gzipDat)
+	if !g.closed {
+		g.csvWriters[idx].Flush()
+		if err := g.csvWriters[idx].Error(); err != nil {
+			return nil, fileErr(
+				err, "Could not flush dat file: %s", g.datNames[idx],
+			)
+		}
+		if compressed {
+			if err := gz.Flush(); err != nil {
+				return nil, fileErr(
+					err, "Could not flush dat file: %s", g.datNames[idx],
+				)
+			}
+		}
+	}
+	if compressed {
+		w = gz.under
+	}
+
+	var raw []byte
+	if buf, ok := w.(*bytes.Buffer); ok {
+		raw = buf.Bytes()
+	} else {
+		var err error
+		if raw, err = os.ReadFile(g.datNames[idx]); err != nil {
+			return nil, fileErr(
+				err, "Could not read dat file: %s", g.datNames[idx],
+			)
+		}
+	}
+	if !compressed || len(raw) == 0 {
+		return raw, nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fileErr(
+			err, "Could not decompress dat file: %s", g.datNames[idx],
+		)
+	}
+	rv, err := io.ReadAll(r)
+	// The gzip footer is only written once the dat file is closed, so an
+	// unexpected EOF is the end of the data written so far.
+	if err != nil && (g.closed || !errors.Is(err, io.ErrUnexpectedEOF)) {
+		return nil, fileErr(
+			err, "Could not decompress dat file: %s", g.datNames[idx],
+		)
+	}
+	return rv, nil
+}
+
+// Writes the bytes directly to the dat file at the supplied index after
+// flushing the csv writer to preserve the order of the written data. The
+// caller is expected to hold the dat files lock.
+func (g *GnuPlot) writeDatRaw(idx int, b []byte) error {
+	if err := g.checkInlineData(idx, b); err != nil {
+		return err
+	}
+	g.csvWriters[idx].Flush()
+	if err := g.csvWriters[idx].Error(); err != nil {
+		return fileErr(err, "Could not write dat file: %s", g.datNames[idx])
+	}
+	if _, err := g.datCounters[idx].Write(b); err != nil {
+		return fileErr(err, "Could not write dat file: %s", g.datNames[idx])
+	}
+	return nil
+}
+
+// Returns a [InvalidDataValueErr] if [GnuPlotOpts.InlineData] is true and
+// writing the bytes would create a line that is exactly `EOD`, which gnuplot
+// would treat as the end of the datablock. The partial last line that is
+// already buffered is included so that lines split across writes are caught,
+// and an unterminated last line is checked as well because a newline is added
+// after it when the datablock is written.
+func (g *GnuPlot) checkInlineData(idx int, b []byte) error {
+	if !g.inlineData {
+		return nil
+	}
+	buffered := g.datWriters[idx].(*bytes.Buffer).Bytes()
+	partial := buffered[bytes.LastIndexByte(buffered, '\n')+1:]
+	lines := bytes.Split(append(slices.Clone(partial), b...), []byte{'\n'})
+	for _, l := range lines {
+		if string(bytes.TrimSuffix(l, []byte{'\r'})) == "EOD" {
+			return sberr.Wrap(
+				InvalidDataValueErr,
+				"Inline data cannot contain a line that is exactly EOD: Dat file: %d",
+				idx,
+			)
+		}
+	}
+	return nil
+}
+
+func (g *GnuPlot) newline() string {
+	if g.opts.UseCRLF {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+func (g *GnuPlot) formatFloat(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return g.opts.MissingValue
+	}
+	if g.floatPrecision < 0 {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'f', g.floatPrecision, 64)
+}
+
+// Locks the dat file at the supplied index if concurrent writes are enabled,
+// returning the function that will unlock it.
+func (g *GnuPlot) lockDat(idx int) func() {
+	if !g.concurrent {
+		return func() {}
+	}
+	g.datLocks[idx].Lock()
+	return g.datLocks[idx].Unlock
+}
+
+func (g *GnuPlot) flushDat(idx int) error {
+	defer g.lockDat(idx)()
+	g.csvWriters[idx].Flush()
+	if err := g.csvWriters[idx].Error(); err != nil {
+		return fileErr(err, "Could not flush dat file: %s", g.datNames[idx])
+	}
+	return nil
+}
+
+func (g *GnuPlot) checkDataValues(idx int, data []string) error {
+	if !g.rejectSep {
+		return nil
+	}
+	invalidChars := string(g.csvWriters[idx].Comma) + "\"\r\n"
+	for i, v := range data {
+		if strings.ContainsAny(v, invalidChars) {
+			return sberr.Wrap(
+				InvalidDataValueErr,
+				"Value contains the separator, a quote, or a newline: Data index: %d Got: %q",
+				i, v,
+			)
+		}
+		// The csv writer also quotes values that start with whitespace and
+		// the value `\.`
+		r, _ := utf8.DecodeRuneInString(v)
+		if v == `\.` || (v != "" && unicode.IsSpace(r)) {
+			return sberr.Wrap(
+				InvalidDataValueErr,
+				"Value starts with whitespace or is \\.: Data index: %d Got: %q",
+				i, v,
+			)
+		}
+	}
+	return nil
+}
+
+func (g *GnuPlot) checkOutIdx(idx int) error {
+	if idx < 0 || idx >= len(g.outFiles) {
+		return sberr.Wrap(
+			InvalidOutIndexErr,
+			"Out file index out of range: Got: %d Allowed Range: [0, %d)",
+			idx, len(g.outFiles),
+		)
+	}
+	return nil
+}
+
+// Checks the dat file index in the same way as [GnuPlot.checkDatIdx] and also
+// checks that the dat files have not been closed.
+func (g *GnuPlot) checkDatWrite(idx int) error {
+	if g.closed {
+		return sberr.Wrap(
+			GnuPlotClosedErr,
+			"Cannot write to dat file after closing: Index: %d", idx,
+		)
+	}
+	return g.checkDatIdx(idx)
+}
+
+func (g *GnuPlot) checkDatIdx(idx int) error {
+	if idx < 0 || idx >= len(g.datWriters) {
+		return sberr.Wrap(
+			InvalidDatIndexErr,
+			"Dat file index out of range: Got: %d Allowed Range: [0, %d)",
+			idx, len(g.datWriters),
+		)
+	}
+	return nil
+}
+
+// Flushes all writers and closes all open files without executing gnuplot. Any
+// errors that occur while closing the files will be aggregated and returned.
+// If buffered data could not be flushed to a dat file, for example because the
+// disk is full, a [DatFlushErr] identifying the dat file will be returned.
+// Calling this method multiple times is safe, only the first call will do any
+// work. Once this method has been called any attempt to write more cmds or
+// data will return a [GnuPlotClosedErr].
+//
+// This allows for the following pattern:
+//
+//	g, err := NewGnuPlot(opts)
+//	if err != nil {
+//		return err
+//	}
+//	defer g.Close()
+func (g *GnuPlot) Close() error {
+	if g.closed {
+		return nil
+	}
+	g.closed = true
+
+	var err error
+	for i := range len(g.datWriters) {
+		g.csvWriters[i].Flush()
+		if fErr := g.csvWriters[i].Error(); fErr != nil {
+			err = sberr.AppendError(err, sberr.AppendError(
+				DatFlushErr,
+				sberr.InverseWrap(
+					fErr, "Index: %d Path: %s", i, g.datNames[i],
+				),
+			))
+		}
+		if c, ok := g.datWriters[i].(io.Closer); ok {
+			if cErr := c.Close(); cErr != nil {
+				err = sberr.AppendError(err, fileErr(
+					cErr, "Could not close dat file: %s", g.datNames[i],
+				))
+			}
+		}
+		if g.jsonMirrors[i] != nil {
+			err = sberr.AppendError(err, g.jsonMirrors[i].close())
+		}
+	}
+	if g.inlineData {
+		script := g.inlineScript()
+		if g.gpltFile != nil {
+			if _, wErr := g.gpltFile.Write(script); wErr != nil {
+				err = sberr.AppendError(err, fileErr(
+					wErr, "Could not write gplt file: %s", g.gpltName,
+				))
+			}
+			if cErr := g.gpltFile.Close(); cErr != nil {
+				err = sberr.AppendError(err, fileErr(
+					cErr, "Could not close gplt file: %s", g.gpltName,
+				))
+			}
+		} else {
+			g.gplt = bytes.NewBuffer(script)
+		}
+	}
+	if c, ok := g.gplt.(io.Closer); ok {
+		if cErr := c.Close(); cErr != nil {
+			err = sberr.AppendError(err, fileErr(
+				cErr, "Could not close gplt file: %s", g.gpltName,
+			))
+		}
+	}
+	return err
+}
+
+// Returns the buffered cmds with the inline datablocks written before them.
+// The csv writers are expected to have been flushed.
+func (g *GnuPlot) inlineScript() []byte {
+	var buf bytes.Buffer
+	for i, w := range g.datWriters {
+		fmt.Fprintf(&buf, "%s << EOD\n", g.datNames[i])
+		data := w.(*bytes.Buffer).Bytes()
+		buf.Write(data)
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			buf.WriteString("\n")
+		}
+		buf.WriteString("EOD\n")
+	}
+	buf.Write(g.gplt.(*bytes.Buffer).Bytes())
+	return buf.Bytes()
+}
+
+// Closes all open files and then recreates the gplt and dat files using the
+// options that were originally supplied to [NewGnuPlot]. All files will be
+// truncated, even if [GnuPlotOpts.Append] was set, and all state such as data
+// headers will be cleared. This allows a single [GnuPlot] to be reused, for
+// example to generate many plots in a loop, after [GnuPlot.Run] was called.
+func (g *GnuPlot) Reset() error {
+	if err := g.Close(); err != nil {
+		return err
+	}
+	opts := g.opts
+	opts.Append = false
+	newG, err := NewGnuPlot(opts)
+	if err != nil {
+		return err
+	}
+	newG.opts.Append = g.opts.Append
+	*g = newG
+	return nil
+}
+
+// Creates a new [GnuPlot] from the supplied options, as if by calling
+// [NewGnuPlot], and copies the gnu plot code that has been generated so far
+// into its gplt file. This allows common setup cmds to be written once and
+// then branched into many variants. The dat files of the new [GnuPlot] are
+// created fresh from the supplied options and no data is copied. Note that
+// the copied cmds have already had their ops resolved, so any `{dat:#}` or
+// `{out:#}` ops that were written before cloning still refer to the files of
+// the original [GnuPlot]. Avoid writing a `set output` cmd before cloning, or
+// set [GnuPlotOpts.AutoTerminal] in the supplied options: the cmds written by
+// [GnuPlotOpts.AutoTerminal] and [GnuPlotOpts.AutoDatafileSeparator] are
+// written after the copied cmds so that they take precedence. The original
+// [GnuPlot] is not modified and can still be used after calling this method.
+//
+// The datablocks of a [GnuPlot] that uses [GnuPlotOpts.InlineData] are part of
+// its gnu plot code and would clash with the datablocks of the clone, so
+// cloning such a [GnuPlot] will return a [InvalidOptsErr].
+func (g *GnuPlot) Clone(newOpts GnuPlotOpts) (GnuPlot, error) {
+	if g.inlineData {
+		return GnuPlot{}, sberr.Wrap(
+			InvalidOptsErr, "A GnuPlot that uses InlineData cannot be cloned",
+		)
+	}
+	script, err := g.Script()
+	if err != nil {
+		return GnuPlot{}, err
+	}
+	// The clone writes its own shebang if it needs one
+	script = strings.TrimPrefix(script, shebang)
+
+	// The automatic cmds are written after the copied cmds
+	cloneOpts := newOpts
+	cloneOpts.AutoTerminal = false
+	cloneOpts.AutoDatafileSeparator = false
+	rv, err := NewGnuPlot(cloneOpts)
+	if err != nil {
+		return GnuPlot{}, err
+	}
+	rv.opts.AutoTerminal = newOpts.AutoTerminal
+	rv.opts.AutoDatafileSeparator = newOpts.AutoDatafileSeparator
+	if _, err := io.WriteString(rv.gplt, script); err != nil {
+		rv.Close()
+		return GnuPlot{}, fileErr(
+			err, "Could not write cloned cmds to gplt file: %s", rv.gpltName,
+		)
+	}
+	if newOpts.AutoTerminal {
+		if err := rv.SetOutput(""); err != nil {
+			rv.Close()
+			return GnuPlot{}, err
+		}
+	}
+	if newOpts.AutoDatafileSeparator {
+		if err := rv.SetDatafileSeparator(); err != nil {
+			rv.Close()
+			return GnuPlot{}, err
+		}
+	}
+	rv.inMultiplot = g.inMultiplot
+	rv.plotted = g.plotted
+	return rv, nil
 }
 
 // Flushes all writers and executes gnuplot with the generated gnu plot code and
-// data files. All open files are closed so the gnuplot object should not be
-// used after calling this method.
+// data files. All open files are closed by calling [GnuPlot.Close] so the
+// gnuplot object should not be used after calling this method.
+//
+// Anything gnuplot writes to stderr will be written to the configured stderr
+// writer as well as captured. If gnuplot exits with an error a
+// [GnuPlotRunErr] will be returned that wraps the [exec.ExitError] and
+// contains the captured stderr output. If the gnuplot executable could not be
+// found a [GnuPlotNotFoundErr] will be returned and if the process could not
+// be started for any other reason a [GnuPlotStartErr] will be returned. Errors
+// that occur while operating on the generated files will wrap [FileErr]. If
+// any dat file could not be flushed a [DatFlushErr] will be returned and
+// gnuplot will not be run, so a plot is never made from truncated data.
 func (g *GnuPlot) Run(ctxt context.Context) error {
-	for i := range len(g.datFiles) {
-		g.csvWriters[i].Flush()
-		g.datFiles[i].Close()
+	return g.run(ctxt, g.stdout, g.stderr)
+}
+
+// Calls [GnuPlot.Run] but writes gnuplot's stdout and stderr to the supplied
+// writers for this invocation only, rather than the writers configured with
+// [GnuPlotOpts.Stdout] and [GnuPlotOpts.Stderr]. If either writer is nil the
+// configured writer will be used instead. Any warnings generated while running
+// will also be written to the supplied stderr writer.
+func (g *GnuPlot) RunWith(
+	ctxt context.Context,
+	stdout io.Writer,
+	stderr io.Writer,
+) error {
+	if stdout == nil {
+		stdout = g.stdout
+	}
+	if stderr == nil {
+		stderr = g.stderr
+	}
+	return g.run(ctxt, stdout, stderr)
+}
+
+// Calls [GnuPlot.Run] and returns the contents of the first out file once
+// gnuplot exits. If there are no out files, or the first out file is empty,
+// the output that gnuplot wrote to stdout will be returned instead. This is
+// useful for when the gnu plot code omits `set output`, causing gnuplot to
+// write the plot to stdout. If [GnuPlotOpts.CleanupOut] is true the out file
+// will be removed after it has been read.
+func (g *GnuPlot) RunBytes(ctxt context.Context) ([]byte, error) {
+	if len(g.outFiles) == 0 || g.outFiles[0] == "" {
+		var buf bytes.Buffer
+		if err := g.run(ctxt, &buf, g.stderr); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	if err := g.run(ctxt, g.stdout, g.stderr); err != nil {
+		return nil, err
+	}
+	rv, err := os.ReadFile(g.outFiles[0])
+	if err != nil {
+		return nil, fileErr(err, "Could not read out file: %s", g.outFiles[0])
+	}
+	if g.cleanupOut {
+		if err := os.Remove(g.outFiles[0]); err != nil {
+			return rv, fileErr(
+				err, "Could not remove out file: %s", g.outFiles[0],
+			)
+		}
+	}
+	return rv, nil
+}
+
+func (g *GnuPlot) run(
+	ctxt context.Context,
+	stdout io.Writer,
+	stderr io.Writer,
+) error {
+	if err := g.Close(); err != nil {
+		return err
+	}
+	if err := g.checkEmptyDats(stderr); err != nil {
+		return err
+	}
+	if g.inMemory {
+		if err := g.writeTempDatFiles(); err != nil {
+			return err
+		}
+		defer g.removeTempDatFiles()
 	}
-	g.gpltFile.Close()
 
 	var cmd *exec.Cmd
-	cmd = exec.CommandContext(ctxt, "gnuplot", "-c", g.gpltFile.Name())
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	flags := []string{}
+	if g.opts.Persist {
+		flags = append(flags, "-persist")
+	}
+	flags = append(flags, g.opts.ExtraArgs...)
+	switch g.runMode {
+	case Stdin:
+		script := g.gplt.(*bytes.Buffer).Bytes()
+		if len(g.args) == 0 {
+			cmd = exec.CommandContext(ctxt, g.binary, flags...)
+			cmd.Stdin = bytes.NewReader(script)
+			break
+		}
+		// Args can only be passed to a script given with -c, so the script
+		// is written to a transient file.
+		name, err := g.writeTempScript(script)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(name)
+		flags = append(flags, "-c", name)
+		cmd = exec.CommandContext(ctxt, g.binary, append(flags, g.args...)...)
+	default:
+		flags = append(flags, "-c", g.gpltName)
+		cmd = exec.CommandContext(ctxt, g.binary, append(flags, g.args...)...)
+	}
+	// Once the context is done and the process is killed this limits how long
+	// to wait for the output to be copied before giving up.
+	cmd.WaitDelay = time.Second
+	if len(g.opts.Env) > 0 {
+		cmd.Env = os.Environ()
+		for _, k := range slices.Sorted(maps.Keys(g.opts.Env)) {
+			cmd.Env = append(cmd.Env, k+"="+g.opts.Env[k])
+		}
+	}
+	var errBuf bytes.Buffer
+	cmd.Stdout = stdout
+	cmd.Stderr = io.MultiWriter(stderr, &errBuf)
 
 	err := cmd.Run()
+	if errors.Is(err, exec.ErrNotFound) {
+		return sberr.AppendError(
+			GnuPlotNotFoundErr,
+			sberr.InverseWrap(err, "Binary: %s", g.binary),
+		)
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) &&
+		!errors.Is(err, exec.ErrWaitDelay) && ctxt.Err() == nil {
+		return sberr.AppendError(
+			GnuPlotStartErr,
+			sberr.InverseWrap(err, "Binary: %s", g.binary),
+		)
+	}
 	if err != nil {
-		return err
+		if errBuf.Len() > 0 {
+			return sberr.AppendError(
+				GnuPlotRunErr,
+				sberr.InverseWrap(
+					err,
+					"gnuplot stderr: %s", strings.TrimSpace(errBuf.String()),
+				),
+			)
+		}
+		return sberr.AppendError(GnuPlotRunErr, err)
+	}
+
+	if g.opts.HTMLWrap {
+		if err := g.writeHTMLWrappers(); err != nil {
+			return err
+		}
+	}
+	if g.cleanupAfter {
+		g.removeGeneratedFiles(stderr)
+	}
+	return nil
+}
+
+// Calls [GnuPlot.Run] with a context that will time out after the supplied
+// duration. If the timeout is reached the gnuplot process will be killed and a
+// [GnuPlotTimeoutErr] will be returned. This is useful as a safety measure for
+// when gnuplot may hang, for example when it is waiting on an interactive
+// terminal.
+func (g *GnuPlot) RunTimeout(d time.Duration) error {
+	ctxt, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	err := g.Run(ctxt)
+	if err != nil && errors.Is(ctxt.Err(), context.DeadlineExceeded) {
+		return sberr.AppendError(
+			GnuPlotTimeoutErr,
+			sberr.InverseWrap(err, "Timeout: %s", d),
+		)
+	}
+	return err
+}
+
+// Calls [GnuPlot.Run] up to `attempts` times, waiting for `backoff` between
+// each attempt. Only errors caused by the gnuplot process failing to start,
+// which will wrap [GnuPlotStartErr], are retried. Errors from gnuplot itself,
+// such as syntax errors in the gnu plot code, are returned immediately as
+// retrying would not change the result. If the context is done while waiting
+// to retry the last error will be returned along with the contexts error. An
+// `attempts` value less than one is treated as one.
+func (g *GnuPlot) RunRetry(
+	ctxt context.Context,
+	attempts int,
+	backoff time.Duration,
+) error {
+	var err error
+	for i := 0; i < max(attempts, 1); i++ {
+		if i > 0 {
+			select {
+			case <-ctxt.Done():
+				return sberr.AppendError(err, ctxt.Err())
+			case <-time.After(backoff):
+			}
+		}
+		if err = g.Run(ctxt); !errors.Is(err, GnuPlotStartErr) {
+			return err
+		}
+	}
+	return sberr.Wrap(err, "Failed after %d attempts", max(attempts, 1))
+}
+
+func (g *GnuPlot) checkEmptyDats(stderr io.Writer) error {
+	if !g.warnEmptyDat && !g.errOnEmptyDat {
+		return nil
+	}
+	for i, referenced := range g.datRefs {
+		if !referenced || g.hasData[i] {
+			continue
+		}
+		if g.errOnEmptyDat {
+			return sberr.Wrap(
+				EmptyDatFileErr,
+				"No data was written to referenced dat file: Index: %d Path: %s",
+				i, g.datNames[i],
+			)
+		}
+		fmt.Fprintf(
+			stderr,
+			"Warning: No data was written to referenced dat file: Index: %d Path: %s\n",
+			i, g.datNames[i],
+		)
+	}
+	return nil
+}
+
+func (g *GnuPlot) writeTempScript(script []byte) (string, error) {
+	dir := g.opts.TempScriptDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	f, err := os.CreateTemp(dir, "sbgnuplot-*"+g.opts.GpltExt)
+	if err != nil {
+		return "", fileErr(err, "Could not create temporary script in: %s", dir)
+	}
+	_, err = f.Write(script)
+	err = sberr.AppendError(err, f.Close())
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fileErr(
+			err, "Could not write temporary script: %s", f.Name(),
+		)
 	}
+	return f.Name(), nil
+}
+
+func (g *GnuPlot) writeTempDatFiles() error {
+	for i, w := range g.datWriters {
+		if gz, ok := w.(*gzipDat); ok {
+			w = gz.under
+		}
+		buf, ok := w.(*bytes.Buffer)
+		if !ok {
+			continue
+		}
+		if err := os.WriteFile(g.datNames[i], buf.Bytes(), 0644); err != nil {
+			g.removeTempDatFiles()
+			return fileErr(
+				err, "Could not write temporary dat file: %s", g.datNames[i],
+			)
+		}
+	}
+	return nil
+}
 
+func (g *GnuPlot) writeHTMLWrappers() error {
+	for _, o := range g.outFiles {
+		svg, err := os.ReadFile(o)
+		if err != nil {
+			return fileErr(err, "Could not read svg out file: %s", o)
+		}
+		// Drop the xml declaration and doctype that precede the svg element
+		start := bytes.Index(svg, []byte("<svg"))
+		if start < 0 {
+			return sberr.Wrap(
+				FileErr, "Out file does not contain an svg element: %s", o,
+			)
+		}
+		var buf bytes.Buffer
+		fmt.Fprintf(
+			&buf,
+			"<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n"+
+				"<title>%s</title>\n</head>\n<body>\n",
+			html.EscapeString(filepath.Base(o)),
+		)
+		buf.Write(bytes.TrimSpace(svg[start:]))
+		buf.WriteString("\n</body>\n</html>\n")
+		if err := os.WriteFile(o+".html", buf.Bytes(), 0666); err != nil {
+			return fileErr(err, "Could not write html file: %s", o+".html")
+		}
+	}
 	return nil
 }
+
+func (g *GnuPlot) removeGeneratedFiles(stderr io.Writer) {
+	var err error
+	if g.runMode != Stdin {
+		err = sberr.AppendError(err, os.Remove(g.gpltName))
+	}
+	if !g.inMemory && !g.inlineData {
+		for _, name := range g.datNames {
+			err = sberr.AppendError(err, os.Remove(name))
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Could not remove generated files: %s\n", err)
+	}
+}
+
+func (g *GnuPlot) removeTempDatFiles() {
+	for _, name := range g.datNames {
+		os.Remove(name)
+	}
+}
@@ -0,0 +1,1501 @@
+package sbgnuplot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestGnuPlot(t *testing.T, opts GnuPlotOpts) GnuPlot {
+	t.Helper()
+	dir := t.TempDir()
+	if opts.GpltFile == "" {
+		opts.GpltFile = filepath.Join(dir, "plot")
+	}
+	if opts.DatFiles == nil {
+		opts.DatFiles = []string{filepath.Join(dir, "data")}
+	}
+	if opts.OutFiles == nil {
+		opts.OutFiles = []string{filepath.Join(dir, "out.png")}
+	}
+	g, err := NewGnuPlot(opts)
+	if err != nil {
+		t.Fatalf("NewGnuPlot: %v", err)
+	}
+	t.Cleanup(func() { g.Close() })
+	return g
+}
+
+// Writes a shell script with the supplied body to a temporary directory and
+// returns its path so that it can be used in place of gnuplot.
+func newFakeBinary(t *testing.T, body string) string {
+	t.Helper()
+	bin := filepath.Join(t.TempDir(), "gnuplot")
+	if err := os.WriteFile(
+		bin, []byte("#!/bin/sh\n"+body+"\n"), 0o755,
+	); err != nil {
+		t.Fatal(err)
+	}
+	return bin
+}
+
+func TestInvalidDatIndex(t *testing.T) {
+	tests := map[string]func(g *GnuPlot, idx int) error{
+		"DatPath": func(g *GnuPlot, idx int) error {
+			_, err := g.DatPath(idx)
+			return err
+		},
+		"RowCount": func(g *GnuPlot, idx int) error {
+			_, err := g.RowCount(idx)
+			return err
+		},
+		"Cmds dat op": func(g *GnuPlot, idx int) error {
+			return g.Cmds("plot ${dat:" + strconv.Itoa(idx) + "}")
+		},
+		"DataRow": func(g *GnuPlot, idx int) error {
+			return g.DataRow(idx, "1")
+		},
+		"DataRowN": func(g *GnuPlot, idx int) error {
+			_, err := g.DataRowN(idx, "1")
+			return err
+		},
+		"DataRows": func(g *GnuPlot, idx int) error {
+			return g.DataRows(idx, [][]string{{"1"}})
+		},
+		"DataRowsFromChan": func(g *GnuPlot, idx int) error {
+			ch := make(chan []string)
+			close(ch)
+			return g.DataRowsFromChan(context.Background(), idx, ch)
+		},
+		"DataHeader": func(g *GnuPlot, idx int) error {
+			return g.DataHeader(idx, "a")
+		},
+		"DataRowf": func(g *GnuPlot, idx int) error {
+			return g.DataRowf(idx, 1)
+		},
+		"DataRowAny": func(g *GnuPlot, idx int) error {
+			return g.DataRowAny(idx, 1)
+		},
+		"DataStructs": func(g *GnuPlot, idx int) error {
+			return g.DataStructs(idx, []struct{ A int }{{1}}, "A")
+		},
+		"DataComment": func(g *GnuPlot, idx int) error {
+			return g.DataComment(idx, "c")
+		},
+		"DataBreak": func(g *GnuPlot, idx int) error {
+			return g.DataBreak(idx)
+		},
+		"DataMatrix": func(g *GnuPlot, idx int) error {
+			return g.DataMatrix(idx, [][]float64{{1}})
+		},
+		"DataMatrixLabeled": func(g *GnuPlot, idx int) error {
+			return g.DataMatrixLabeled(
+				idx, []string{"r"}, []string{"c"}, [][]float64{{1}},
+			)
+		},
+		"DataFromReader": func(g *GnuPlot, idx int) error {
+			return g.DataFromReader(idx, strings.NewReader("1\n"))
+		},
+		"DataFromCsvReader": func(g *GnuPlot, idx int) error {
+			return g.DataFromCsvReader(idx, strings.NewReader("1\n"), ',')
+		},
+		"DataRaw": func(g *GnuPlot, idx int) error {
+			return g.DataRaw(idx, []byte("1\n"))
+		},
+		"DataBinaryRow": func(g *GnuPlot, idx int) error {
+			return g.DataBinaryRow(idx, 1)
+		},
+		"ReadDataFile": func(g *GnuPlot, idx int) error {
+			_, err := g.ReadDataFile(idx)
+			return err
+		},
+		"PlotSeries": func(g *GnuPlot, idx int) error {
+			return g.PlotSeries(Series{DatIdx: idx})
+		},
+		"Splot": func(g *GnuPlot, idx int) error {
+			return g.Splot(Series{DatIdx: idx})
+		},
+		"PlotBuilder": func(g *GnuPlot, idx int) error {
+			return g.PlotBuilder().Line(idx, "1:2").Build()
+		},
+		"Histogram": func(g *GnuPlot, idx int) error {
+			return g.Histogram(idx, HistogramOpts{BinWidth: 1})
+		},
+		"Fit": func(g *GnuPlot, idx int) error {
+			return g.Fit(idx, "f(x)", "1:2", "a")
+		},
+	}
+	for name, fn := range tests {
+		for _, idx := range []int{-1, 1} {
+			t.Run(name+"/"+strconv.Itoa(idx), func(t *testing.T) {
+				g := newTestGnuPlot(t, GnuPlotOpts{})
+				if err := fn(&g, idx); !errors.Is(err, InvalidDatIndexErr) {
+					t.Fatalf("expected InvalidDatIndexErr, got: %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(g *GnuPlot) error
+		want error
+	}{
+		{"Cmds unknown op", func(g *GnuPlot) error {
+			return g.Cmds("${nope}")
+		}, InvalidOpErr},
+		{"Cmds unterminated op", func(g *GnuPlot) error {
+			return g.Cmds("${dat:0")
+		}, UnterminatedOpErr},
+		{"Cmds invalid dat op", func(g *GnuPlot) error {
+			return g.Cmds("${dat:x}")
+		}, InvalidDatOpErr},
+		{"Cmds bin op without binary rows", func(g *GnuPlot) error {
+			return g.Cmds("${bin:0}")
+		}, InvalidDatOpErr},
+		{"Cmds invalid out index", func(g *GnuPlot) error {
+			return g.Cmds("${out:3}")
+		}, InvalidOutIndexErr},
+		{"Cmds invalid arg op", func(g *GnuPlot) error {
+			return g.Cmds("${arg:9}")
+		}, InvalidArgOpErr},
+		{"Cmds now op without time elements", func(g *GnuPlot) error {
+			return g.Cmds("${now:abc}")
+		}, InvalidOpErr},
+		{"CmdsTemplate", func(g *GnuPlot) error {
+			return g.CmdsTemplate("{{ .Nope", nil)
+		}, InvalidTemplateErr},
+		{"OutPath", func(g *GnuPlot) error {
+			_, err := g.OutPath(2)
+			return err
+		}, InvalidOutIndexErr},
+		{"DataHeader after rows", func(g *GnuPlot) error {
+			g.DataRow(0, "1")
+			return g.DataHeader(0, "a")
+		}, InvalidDataHeaderErr},
+		{"DataRowAny unsupported type", func(g *GnuPlot) error {
+			return g.DataRowAny(0, []int{1})
+		}, UnsupportedDataTypeErr},
+		{"DataStructs not a slice", func(g *GnuPlot) error {
+			return g.DataStructs(0, 1, "A")
+		}, InvalidStructDataErr},
+		{"DataMatrix ragged", func(g *GnuPlot) error {
+			return g.DataMatrix(0, [][]float64{{1, 2}, {3}})
+		}, RaggedMatrixErr},
+		{"DataMatrixLabeled ragged", func(g *GnuPlot) error {
+			return g.DataMatrixLabeled(
+				0, []string{"a", "b"}, []string{"x", "y"},
+				[][]float64{{1, 2}, {3}},
+			)
+		}, RaggedMatrixErr},
+		{"DataMatrixLabeled row names", func(g *GnuPlot) error {
+			return g.DataMatrixLabeled(
+				0, []string{"a"}, []string{"x"}, [][]float64{{1}, {2}},
+			)
+		}, InvalidMatrixLabelsErr},
+		{"DataMatrixLabeled col names", func(g *GnuPlot) error {
+			return g.DataMatrixLabeled(
+				0, []string{"a"}, []string{"x", "y"}, [][]float64{{1}},
+			)
+		}, InvalidMatrixLabelsErr},
+		{"DataMatrixLabeled quoted label", func(g *GnuPlot) error {
+			return g.DataMatrixLabeled(
+				0, []string{`"a`}, []string{"x"}, [][]float64{{1}},
+			)
+		}, InvalidMatrixLabelsErr},
+		{"DataBinaryRow column mismatch", func(g *GnuPlot) error {
+			g.DataBinaryRow(0, 1, 2)
+			return g.DataBinaryRow(0, 1)
+		}, InvalidBinaryRowErr},
+		{"DataFromCsvReader bad csv", func(g *GnuPlot) error {
+			return g.DataFromCsvReader(0, strings.NewReader("\"a\n"), ',')
+		}, DataReadErr},
+		{"DataFromReader failing reader", func(g *GnuPlot) error {
+			return g.DataFromReader(0, errReader{})
+		}, DataReadErr},
+		{"DataComment line break", func(g *GnuPlot) error {
+			return g.DataComment(0, "a\nb")
+		}, InvalidDataValueErr},
+		{"Set invalid option", func(g *GnuPlot) error {
+			return g.Set("a b")
+		}, InvalidOptionErr},
+		{"SetDatafileMissing mismatch", func(g *GnuPlot) error {
+			return g.SetDatafileMissing("NA")
+		}, InvalidMissingErr},
+		{"SetOutput unknown ext", func(g *GnuPlot) error {
+			g.outFiles[0] = "out.unknown"
+			return g.SetOutput("")
+		}, UnknownTerminalErr},
+		{"SetSize", func(g *GnuPlot) error {
+			return g.SetSize(0, 10)
+		}, InvalidSizeErr},
+		{"SetRange axis", func(g *GnuPlot) error {
+			return g.SetRange("q", 0, 1)
+		}, InvalidAxisErr},
+		{"SetRange bounds", func(g *GnuPlot) error {
+			return g.SetRange("x", math.NaN(), 1)
+		}, InvalidRangeErr},
+		{"SetKey", func(g *GnuPlot) error {
+			return g.SetKey("sideways")
+		}, InvalidKeyPosErr},
+		{"SetLogScale axis", func(g *GnuPlot) error {
+			return g.SetLogScale("q", 10)
+		}, InvalidAxisErr},
+		{"SetLogScale base", func(g *GnuPlot) error {
+			return g.SetLogScale("x", 1)
+		}, InvalidLogBaseErr},
+		{"UnsetLogScale", func(g *GnuPlot) error {
+			return g.UnsetLogScale("q")
+		}, InvalidAxisErr},
+		{"Pause", func(g *GnuPlot) error {
+			return g.Pause(math.NaN())
+		}, InvalidPauseErr},
+		{"SetBorder", func(g *GnuPlot) error {
+			return g.SetBorder(5000)
+		}, InvalidBorderErr},
+		{"SetSamples", func(g *GnuPlot) error {
+			return g.SetSamples(0)
+		}, InvalidSamplesErr},
+		{"SetIsosamples", func(g *GnuPlot) error {
+			return g.SetIsosamples(1, MaxIsosamples+1)
+		}, InvalidSamplesErr},
+		{"SetPalette unknown", func(g *GnuPlot) error {
+			return g.SetPalette(Palette{Name: "nope"})
+		}, InvalidPaletteErr},
+		{"SetPalette formulae", func(g *GnuPlot) error {
+			return g.SetPalette(Palette{RGBFormulae: []int{1, 2, 40}})
+		}, InvalidPaletteErr},
+		{"SetView", func(g *GnuPlot) error {
+			return g.SetView(-1, 0)
+		}, InvalidViewErr},
+		{"TitleFromOutFile", func(g *GnuPlot) error {
+			g.outFiles = nil
+			return g.TitleFromOutFile()
+		}, InvalidOutIndexErr},
+		{"PlotSeries empty", func(g *GnuPlot) error {
+			return g.PlotSeries()
+		}, EmptyPlotErr},
+		{"PlotSeries axes", func(g *GnuPlot) error {
+			return g.PlotSeries(Series{Axes: "x3y1"})
+		}, InvalidAxisErr},
+		{"PlotSeries line style", func(g *GnuPlot) error {
+			return g.PlotSeries(Series{LineStyle: -1})
+		}, InvalidLineStyleErr},
+		{"PlotSeries xtic", func(g *GnuPlot) error {
+			return g.PlotSeries(Series{XTicColumn: 1})
+		}, InvalidSeriesErr},
+		{"Replot without plot", func(g *GnuPlot) error {
+			return g.Replot(Series{})
+		}, ReplotWithoutPlotErr},
+		{"PlotFunc empty", func(g *GnuPlot) error {
+			return g.PlotFunc()
+		}, EmptyPlotErr},
+		{"PlotBuilder empty", func(g *GnuPlot) error {
+			return g.PlotBuilder().Build()
+		}, EmptyPlotErr},
+		{"Histogram bin width", func(g *GnuPlot) error {
+			return g.Histogram(0, HistogramOpts{})
+		}, InvalidHistogramOptsErr},
+		{"BeginMultiplot twice", func(g *GnuPlot) error {
+			g.BeginMultiplot(1, 2, "")
+			return g.BeginMultiplot(1, 2, "")
+		}, MultiplotErr},
+		{"EndMultiplot without begin", func(g *GnuPlot) error {
+			return g.EndMultiplot()
+		}, MultiplotErr},
+		{"Fit without vars", func(g *GnuPlot) error {
+			return g.Fit(0, "f(x)", "1:2")
+		}, InvalidFitErr},
+		{"DefineLineStyle", func(g *GnuPlot) error {
+			return g.DefineLineStyle(0, LineStyle{})
+		}, InvalidLineStyleErr},
+		{"DefineFunc", func(g *GnuPlot) error {
+			return g.DefineFunc("f", "x")
+		}, InvalidFuncErr},
+		{"SetVar name", func(g *GnuPlot) error {
+			return g.SetVar("1a", 1)
+		}, InvalidVarErr},
+		{"SetVar value", func(g *GnuPlot) error {
+			return g.SetVar("a", math.Inf(1))
+		}, InvalidVarErr},
+		{"SetVar type", func(g *GnuPlot) error {
+			return g.SetVar("a", []int{})
+		}, UnsupportedDataTypeErr},
+		{"AddDatFile after close", func(g *GnuPlot) error {
+			g.Close()
+			_, err := g.AddDatFile("other")
+			return err
+		}, GnuPlotClosedErr},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newTestGnuPlot(t, GnuPlotOpts{})
+			if err := tc.fn(&g); !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got: %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestInvalidOpts(t *testing.T) {
+	tests := []struct {
+		name string
+		opts GnuPlotOpts
+		want error
+	}{
+		{"no out files", GnuPlotOpts{OutFiles: []string{}}, EmptyOutFileErr},
+		{"empty out file", GnuPlotOpts{OutFiles: []string{""}}, EmptyOutFileErr},
+		{"csv sep", GnuPlotOpts{CsvSep: '"'}, InvalidOptsErr},
+		{"missing value", GnuPlotOpts{
+			CsvSep: ',', MissingValue: "a,b",
+		}, InvalidOptsErr},
+		{"stdin with args", GnuPlotOpts{
+			RunMode: Stdin, Args: []string{"a"},
+		}, InvalidOptsErr},
+		{"persist with out", GnuPlotOpts{Persist: true}, InvalidOptsErr},
+		{"inline and memory", GnuPlotOpts{
+			InlineData: true, InMemory: true,
+		}, InvalidOptsErr},
+		{"shebang with stdin", GnuPlotOpts{
+			RunMode: Stdin, Shebang: true,
+		}, InvalidOptsErr},
+		{"extra args -c", GnuPlotOpts{
+			ExtraArgs: []string{"-c"},
+		}, InvalidOptsErr},
+		{"html wrap png", GnuPlotOpts{HTMLWrap: true}, InvalidOptsErr},
+		{"json mirror ext", GnuPlotOpts{
+			MirrorJSON: true, DatExt: ".json",
+		}, InvalidOptsErr},
+		{"verify install", GnuPlotOpts{
+			VerifyInstall: true, GnuPlotBinary: "sbgnuplot-does-not-exist",
+		}, GnuPlotNotFoundErr},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tc.opts.GpltFile = filepath.Join(dir, "plot")
+			if tc.opts.OutFiles == nil {
+				tc.opts.OutFiles = []string{filepath.Join(dir, "out.png")}
+			}
+			_, err := NewGnuPlot(tc.opts)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got: %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestFileErrWrapsCause(t *testing.T) {
+	t.Run("create", func(t *testing.T) {
+		dir := t.TempDir()
+		_, err := NewGnuPlot(GnuPlotOpts{
+			GpltFile: filepath.Join(dir, "missing", "plot"),
+			OutFiles: []string{filepath.Join(dir, "out.png")},
+		})
+		var pathErr *fs.PathError
+		if !errors.Is(err, FileErr) || !errors.As(err, &pathErr) {
+			t.Fatalf("expected FileErr wrapping a PathError, got: %v", err)
+		}
+	})
+	t.Run("write", func(t *testing.T) {
+		if _, err := os.Stat("/dev/full"); err != nil {
+			t.Skip("/dev/full is not available")
+		}
+		dir := t.TempDir()
+		dat := filepath.Join(dir, "data")
+		if err := os.Symlink("/dev/full", dat+".dat"); err != nil {
+			t.Fatal(err)
+		}
+		g := newTestGnuPlot(t, GnuPlotOpts{DatFiles: []string{dat}})
+		_, err := g.DataRowN(0, "1")
+		var pathErr *fs.PathError
+		if !errors.Is(err, FileErr) || !errors.As(err, &pathErr) {
+			t.Fatalf("expected FileErr wrapping a PathError, got: %v", err)
+		}
+		g.DataRow(0, "2")
+		if err := g.Close(); !errors.Is(err, DatFlushErr) {
+			t.Fatalf("expected DatFlushErr, got: %v", err)
+		}
+	})
+	t.Run("chan final flush", func(t *testing.T) {
+		if _, err := os.Stat("/dev/full"); err != nil {
+			t.Skip("/dev/full is not available")
+		}
+		dir := t.TempDir()
+		dat := filepath.Join(dir, "data")
+		if err := os.Symlink("/dev/full", dat+".dat"); err != nil {
+			t.Fatal(err)
+		}
+		g := newTestGnuPlot(t, GnuPlotOpts{DatFiles: []string{dat}})
+		rows := make(chan []string, 1)
+		rows <- []string{"1"}
+		close(rows)
+		err := g.DataRowsFromChan(context.Background(), 0, rows)
+		var pathErr *fs.PathError
+		if !errors.Is(err, FileErr) || !errors.As(err, &pathErr) {
+			t.Fatalf("expected FileErr wrapping a PathError, got: %v", err)
+		}
+	})
+	t.Run("max dat bytes", func(t *testing.T) {
+		tests := map[string]func(g *GnuPlot) error{
+			"DataComment": func(g *GnuPlot) error {
+				return g.DataComment(0, "too long")
+			},
+			"DataRow": func(g *GnuPlot) error {
+				return g.DataRow(0, "too", "long")
+			},
+			"DataHeader": func(g *GnuPlot) error {
+				return g.DataHeader(0, "too", "long")
+			},
+		}
+		for name, fn := range tests {
+			t.Run(name, func(t *testing.T) {
+				g := newTestGnuPlot(t, GnuPlotOpts{MaxDatBytes: 6})
+				if err := g.DataComment(0, "1"); err != nil {
+					t.Fatal(err)
+				}
+				if err := fn(&g); !errors.Is(err, DatFileTooLargeErr) ||
+					!errors.Is(err, FileErr) {
+					t.Fatalf("expected DatFileTooLargeErr, got: %v", err)
+				}
+				if err := g.DataRow(0, "2"); !errors.Is(
+					err, DatFileTooLargeErr,
+				) {
+					t.Fatalf("expected the limit to stay exceeded, got: %v", err)
+				}
+				if err := g.Close(); err != nil {
+					t.Fatalf("expected Close to succeed, got: %v", err)
+				}
+				got, err := os.ReadFile(g.datNames[0])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != "# 1\n" {
+					t.Fatalf("expected %q, got %q", "# 1\n", got)
+				}
+				if err := g.Reset(); err != nil {
+					t.Fatalf("expected Reset to succeed, got: %v", err)
+				}
+				if err := g.DataRow(0, "3"); err != nil {
+					t.Fatalf("expected the limit to be reset, got: %v", err)
+				}
+			})
+		}
+	})
+}
+
+func TestRunErrors(t *testing.T) {
+	t.Run("not found", func(t *testing.T) {
+		g := newTestGnuPlot(t, GnuPlotOpts{
+			GnuPlotBinary: "sbgnuplot-does-not-exist",
+		})
+		var execErr *exec.Error
+		err := g.Run(context.Background())
+		if !errors.Is(err, GnuPlotNotFoundErr) || !errors.As(err, &execErr) {
+			t.Fatalf("expected GnuPlotNotFoundErr, got: %v", err)
+		}
+	})
+	t.Run("exit error", func(t *testing.T) {
+		bin, err := exec.LookPath("false")
+		if err != nil {
+			t.Skip("false is not available")
+		}
+		g := newTestGnuPlot(t, GnuPlotOpts{GnuPlotBinary: bin})
+		var exitErr *exec.ExitError
+		err = g.RunWith(context.Background(), &bytes.Buffer{}, &bytes.Buffer{})
+		if !errors.Is(err, GnuPlotRunErr) || !errors.As(err, &exitErr) {
+			t.Fatalf("expected GnuPlotRunErr, got: %v", err)
+		}
+	})
+	t.Run("timeout", func(t *testing.T) {
+		bin := newFakeBinary(t, "exec sleep 5")
+		g := newTestGnuPlot(t, GnuPlotOpts{GnuPlotBinary: bin})
+		if err := g.RunTimeout(10 * time.Millisecond); !errors.Is(
+			err, GnuPlotTimeoutErr,
+		) {
+			t.Fatalf("expected GnuPlotTimeoutErr, got: %v", err)
+		}
+	})
+}
+
+func TestRejectSeparatorInValue(t *testing.T) {
+	g := newTestGnuPlot(t, GnuPlotOpts{RejectSeparatorInValue: true})
+	for _, v := range []string{"a\tb", `a"b`, "a\nb", " a", "\ta", `\.`} {
+		if err := g.DataRow(0, v); !errors.Is(err, InvalidDataValueErr) {
+			t.Errorf("expected InvalidDataValueErr for %q, got: %v", v, err)
+		}
+	}
+	for _, v := range []string{"", "a b", `\.a`} {
+		if err := g.DataRow(0, v); err != nil {
+			t.Errorf("expected no error for %q, got: %v", v, err)
+		}
+	}
+}
+
+func TestScriptOutput(t *testing.T) {
+	tests := []struct {
+		name string
+		opts GnuPlotOpts
+		fn   func(g *GnuPlot) error
+		want string
+	}{
+		{"SetTitle escapes ops", GnuPlotOpts{}, func(g *GnuPlot) error {
+			return g.SetTitle("cost ${USD} it's")
+		}, "set title 'cost ${USD} it''s'\n"},
+		{"SetVar", GnuPlotOpts{}, func(g *GnuPlot) error {
+			return errors.Join(
+				g.SetVar("a", 5), g.SetVar("b", 5.0), g.SetVar("c", "${x}"),
+			)
+		}, "a = 5\nb = 5.0\nc = '${x}'\n"},
+		{"grid, y2, and pause", GnuPlotOpts{}, func(g *GnuPlot) error {
+			return errors.Join(
+				g.SetGrid(), g.UnsetGrid(), g.EnableY2("b"), g.PauseMouse(),
+			)
+		}, "set grid\nunset grid\nset ytics nomirror\nset y2tics\n" +
+			"set y2label 'b'\npause mouse\n"},
+		{"DefineLineStyle escapes ops", GnuPlotOpts{}, func(g *GnuPlot) error {
+			return g.DefineLineStyle(1, LineStyle{Color: "${c}"})
+		}, "set style line 1 linecolor rgb '${c}'\n"},
+		{"BeginMultiplot escapes ops", GnuPlotOpts{}, func(g *GnuPlot) error {
+			return g.BeginMultiplot(1, 2, "${m}")
+		}, "set multiplot layout 1,2 title '${m}'\n"},
+		{"EnableY2 escapes ops", GnuPlotOpts{}, func(g *GnuPlot) error {
+			return g.EnableY2("${y2}")
+		}, "set ytics nomirror\nset y2tics\nset y2label '${y2}'\n"},
+		{"Series title escapes ops", GnuPlotOpts{}, func(g *GnuPlot) error {
+			g.datNames[0] = "data.dat"
+			return g.PlotSeries(
+				Series{Title: "a ${dat:0}"}, Series{Title: "${x}"},
+			)
+		}, "plot 'data.dat' title 'a ${dat:0}', 'data.dat' title '${x}'\n"},
+		{"Histogram escapes ops", GnuPlotOpts{}, func(g *GnuPlot) error {
+			g.datNames[0] = "data.dat"
+			return g.Histogram(0, HistogramOpts{
+				BinWidth: 1, Title: "${t}", SeriesTitle: "${s}",
+			})
+		}, "set title '${t}'\nset style fill solid 0.5\n" +
+			"set boxwidth 1 absolute\n" +
+			"histogram_bin(x) = 1*floor(x/1)+1/2.0\n" +
+			"plot 'data.dat' using (histogram_bin($1)):(1.0) smooth freq " +
+			"with boxes title '${s}'\n"},
+		{"PlotBuilder escapes ops", GnuPlotOpts{}, func(g *GnuPlot) error {
+			g.datNames[0] = "data.dat"
+			return g.PlotBuilder().Title("cost ${USD}").XLabel("${x}").
+				YLabel("a\nb").Line(0, "").Build()
+		}, "set title 'cost ${USD}'\nset xlabel '${x}'\n" +
+			"set ylabel \"a\\nb\"\nplot 'data.dat' with lines\n"},
+		{"failed Cmds write nothing", GnuPlotOpts{}, func(g *GnuPlot) error {
+			if err := g.Cmds("set grid", "${nope}"); !errors.Is(
+				err, InvalidOpErr,
+			) {
+				return err
+			}
+			return nil
+		}, ""},
+		{"PlotBuilder", GnuPlotOpts{}, func(g *GnuPlot) error {
+			g.datNames[0] = "data.dat"
+			return g.PlotBuilder().Title("t").XLabel("x").YLabel("y").
+				Line(0, "1:2").Build()
+		}, "set title 't'\nset xlabel 'x'\nset ylabel 'y'\n" +
+			"plot 'data.dat' using 1:2 with lines\n"},
+		{"SetDatafileSeparator", GnuPlotOpts{CsvSep: ','}, func(
+			g *GnuPlot,
+		) error {
+			return g.SetDatafileSeparator()
+		}, "set datafile separator comma\n"},
+		{"Compressed dat path", GnuPlotOpts{CompressDat: true}, func(
+			g *GnuPlot,
+		) error {
+			g.datNames[0] = "bob's.dat.gz"
+			return g.Cmds("plot ${dat:0}")
+		}, `plot '< gzip -dc ''bob''\''''s.dat.gz'''` + "\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newTestGnuPlot(t, tc.opts)
+			if err := tc.fn(&g); err != nil {
+				t.Fatal(err)
+			}
+			got, err := g.Script()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDataFormatting(t *testing.T) {
+	zero, neg := 0, -1
+	tests := []struct {
+		name string
+		opts GnuPlotOpts
+		fn   func(g *GnuPlot) error
+		want string
+	}{
+		{"shortest floats", GnuPlotOpts{}, func(g *GnuPlot) error {
+			return g.DataRowf(0, 1e-20, 1e25, 1.5)
+		}, "1e-20\t1e+25\t1.5\n"},
+		{"zero precision", GnuPlotOpts{FloatPrecision: &zero}, func(
+			g *GnuPlot,
+		) error {
+			return g.DataRowf(0, 1.4, 2)
+		}, "1\t2\n"},
+		{"negative precision", GnuPlotOpts{FloatPrecision: &neg}, func(
+			g *GnuPlot,
+		) error {
+			return g.DataRowf(0, 0.1, 1e-20)
+		}, "0.1\t1e-20\n"},
+		{"missing values", GnuPlotOpts{}, func(g *GnuPlot) error {
+			return g.DataRowf(0, math.NaN(), math.Inf(1))
+		}, "?\t?\n"},
+		{"matrix uses CsvSep", GnuPlotOpts{}, func(g *GnuPlot) error {
+			return g.DataMatrix(0, [][]float64{{1, 2}, {3, 4}})
+		}, "1\t2\n3\t4\n"},
+		{"labeled matrix", GnuPlotOpts{CsvSep: ','}, func(g *GnuPlot) error {
+			return g.DataMatrixLabeled(
+				0, []string{"a", "b"}, []string{"x", "y"},
+				[][]float64{{1, 2}, {3, 4}},
+			)
+		}, "\"\",\"x\",\"y\"\n\"a\",1,2\n\"b\",3,4\n"},
+		{"crlf", GnuPlotOpts{UseCRLF: true}, func(g *GnuPlot) error {
+			return errors.Join(g.DataRow(0, "1"), g.DataBreak(0))
+		}, "1\r\n\r\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newTestGnuPlot(t, tc.opts)
+			if err := tc.fn(&g); err != nil {
+				t.Fatal(err)
+			}
+			if err := g.Close(); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(g.datNames[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestInlineData(t *testing.T) {
+	t.Run("unterminated raw data", func(t *testing.T) {
+		g := newTestGnuPlot(t, GnuPlotOpts{InlineData: true})
+		if err := errors.Join(
+			g.DataRaw(0, []byte("1 2")), g.Cmds("plot ${dat:0}"),
+		); err != nil {
+			t.Fatal(err)
+		}
+		got, err := g.Script()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "$Data0 << EOD\n1 2\nEOD\nplot $Data0\n"
+		if got != want {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+	})
+	tests := map[string]func(g *GnuPlot) error{
+		"DataRow": func(g *GnuPlot) error {
+			return g.DataRow(0, "EOD")
+		},
+		"DataRaw": func(g *GnuPlot) error {
+			return g.DataRaw(0, []byte("1\nEOD\n2\n"))
+		},
+		"DataRaw split": func(g *GnuPlot) error {
+			if err := g.DataRaw(0, []byte("1\nEO")); err != nil {
+				return err
+			}
+			return g.DataRaw(0, []byte("D\r\n"))
+		},
+		"DataFromReader": func(g *GnuPlot) error {
+			return g.DataFromReader(0, strings.NewReader("EOD"))
+		},
+	}
+	for name, fn := range tests {
+		t.Run(name, func(t *testing.T) {
+			g := newTestGnuPlot(t, GnuPlotOpts{InlineData: true})
+			if err := fn(&g); !errors.Is(err, InvalidDataValueErr) {
+				t.Fatalf("expected InvalidDataValueErr, got: %v", err)
+			}
+		})
+	}
+	t.Run("EOD inside a value", func(t *testing.T) {
+		g := newTestGnuPlot(t, GnuPlotOpts{InlineData: true})
+		if err := g.DataRow(0, "EODx", "EOD"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestClone(t *testing.T) {
+	dir := t.TempDir()
+	g := newTestGnuPlot(t, GnuPlotOpts{Shebang: true})
+	if err := g.SetTitle("common"); err != nil {
+		t.Fatal(err)
+	}
+	c, err := g.Clone(GnuPlotOpts{
+		GpltFile:     filepath.Join(dir, "clone"),
+		OutFiles:     []string{filepath.Join(dir, "clone.svg")},
+		Shebang:      true,
+		AutoTerminal: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	got, err := c.Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := shebang + "set title 'common'\nset terminal svg\nset output " +
+		quote(filepath.Join(dir, "clone.svg")) + "\n"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	inline := newTestGnuPlot(t, GnuPlotOpts{InlineData: true})
+	if _, err := inline.Clone(GnuPlotOpts{
+		GpltFile: filepath.Join(dir, "inline"),
+		OutFiles: []string{filepath.Join(dir, "inline.png")},
+	}); !errors.Is(err, InvalidOptsErr) {
+		t.Fatalf("expected InvalidOptsErr, got: %v", err)
+	}
+}
+
+func TestLifecycleErrors(t *testing.T) {
+	missing := "sbgnuplot-does-not-exist"
+	tests := []struct {
+		name string
+		fn   func(t *testing.T) error
+		want error
+	}{
+		{"NewGnuPlotContext done", func(t *testing.T) error {
+			ctxt, cancel := context.WithCancel(context.Background())
+			cancel()
+			dir := t.TempDir()
+			_, err := NewGnuPlotContext(ctxt, GnuPlotOpts{
+				GpltFile: filepath.Join(dir, "plot"),
+				OutFiles: []string{filepath.Join(dir, "out.png")},
+			})
+			return err
+		}, context.Canceled},
+		{"NewGnuPlotTSV", func(t *testing.T) error {
+			_, err := NewGnuPlotTSV(GnuPlotOpts{})
+			return err
+		}, EmptyOutFileErr},
+		{"NewGnuPlot verify install", func(t *testing.T) error {
+			dir := t.TempDir()
+			_, err := NewGnuPlot(GnuPlotOpts{
+				GpltFile:      filepath.Join(dir, "plot"),
+				OutFiles:      []string{filepath.Join(dir, "out.png")},
+				GnuPlotBinary: missing,
+				VerifyInstall: true,
+			})
+			return err
+		}, GnuPlotNotFoundErr},
+		{"ClearCmds after close", func(t *testing.T) error {
+			g := newTestGnuPlot(t, GnuPlotOpts{})
+			g.Close()
+			return g.ClearCmds()
+		}, GnuPlotClosedErr},
+		{"Cmds after close", func(t *testing.T) error {
+			g := newTestGnuPlot(t, GnuPlotOpts{})
+			g.Close()
+			return g.Cmds("set grid")
+		}, GnuPlotClosedErr},
+		{"DataRow after close", func(t *testing.T) error {
+			g := newTestGnuPlot(t, GnuPlotOpts{})
+			g.Close()
+			return g.DataRow(0, "1")
+		}, GnuPlotClosedErr},
+		{"DataRaw after close", func(t *testing.T) error {
+			g := newTestGnuPlot(t, GnuPlotOpts{})
+			g.Close()
+			return g.DataRaw(0, []byte("1\n"))
+		}, GnuPlotClosedErr},
+		{"Cmds write error", func(t *testing.T) error {
+			g := newTestGnuPlot(t, GnuPlotOpts{})
+			g.gplt.(*os.File).Close()
+			err := g.Cmds("set grid")
+			var pathErr *fs.PathError
+			if !errors.As(err, &pathErr) {
+				t.Errorf("expected a PathError, got: %v", err)
+			}
+			return err
+		}, FileErr},
+		{"Reset", func(t *testing.T) error {
+			g := newTestGnuPlot(t, GnuPlotOpts{})
+			g.opts.GnuPlotBinary = missing
+			g.opts.VerifyInstall = true
+			return g.Reset()
+		}, GnuPlotNotFoundErr},
+		{"RunBytes", func(t *testing.T) error {
+			g := newTestGnuPlot(t, GnuPlotOpts{GnuPlotBinary: missing})
+			_, err := g.RunBytes(context.Background())
+			return err
+		}, GnuPlotNotFoundErr},
+		{"RunRetry", func(t *testing.T) error {
+			g := newTestGnuPlot(t, GnuPlotOpts{GnuPlotBinary: missing})
+			return g.RunRetry(context.Background(), 2, time.Millisecond)
+		}, GnuPlotNotFoundErr},
+		{"Run with empty dat", func(t *testing.T) error {
+			g := newTestGnuPlot(t, GnuPlotOpts{
+				GnuPlotBinary: missing, ErrOnEmptyDat: true,
+			})
+			g.Cmds("plot ${dat:0}")
+			return g.Run(context.Background())
+		}, EmptyDatFileErr},
+		{"Run with raw data", func(t *testing.T) error {
+			g := newTestGnuPlot(t, GnuPlotOpts{
+				GnuPlotBinary: missing, ErrOnEmptyDat: true,
+			})
+			g.Cmds("plot ${dat:0}")
+			g.DataRaw(0, []byte("1 2\n"))
+			if errs := EmptyDatRule(&g, nil); len(errs) != 0 {
+				t.Errorf("expected no lint errors, got: %v", errs)
+			}
+			return g.Run(context.Background())
+		}, GnuPlotNotFoundErr},
+		{"Validate plot without output", func(t *testing.T) error {
+			g := newTestGnuPlot(t, GnuPlotOpts{
+				LintRules: []LintRule{PlotWithoutOutputRule},
+			})
+			g.Cmds("plot ${dat:0}")
+			return errors.Join(g.Validate()...)
+		}, PlotWithoutOutputErr},
+		{"Validate empty dat", func(t *testing.T) error {
+			g := newTestGnuPlot(t, GnuPlotOpts{
+				LintRules: []LintRule{EmptyDatRule},
+			})
+			g.Cmds("plot ${dat:0}")
+			return errors.Join(g.Validate()...)
+		}, EmptyDatFileErr},
+		{"Persist without out files", func(t *testing.T) error {
+			dir := t.TempDir()
+			g, err := NewGnuPlot(GnuPlotOpts{
+				GpltFile: filepath.Join(dir, "plot"), Persist: true,
+			})
+			if err != nil {
+				return err
+			}
+			defer g.Close()
+			_, err = g.OutPath(0)
+			return err
+		}, InvalidOutIndexErr},
+		{"TerminalForExt", func(t *testing.T) error {
+			_, err := TerminalForExt("out.unknown")
+			return err
+		}, UnknownTerminalErr},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.fn(t); !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got: %v", tc.want, err)
+			}
+		})
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+// A fake gnuplot that prints each of its args, the contents of any args that
+// are files other than the script, and then the script it was given.
+const echoGnuPlot = `script=
+prev=
+for a in "$@"; do
+	echo "arg: $a"
+	if [ "$prev" = "-c" ]; then
+		script=$a
+	elif [ -f "$a" ]; then
+		cat "$a"
+	fi
+	prev=$a
+done
+if [ -n "$script" ]; then cat "$script"; else cat; fi`
+
+func TestRunModes(t *testing.T) {
+	bin := newFakeBinary(t, echoGnuPlot)
+	t.Run("file", func(t *testing.T) {
+		g := newTestGnuPlot(t, GnuPlotOpts{
+			GnuPlotBinary: bin,
+			Args:          []string{"x"},
+			ExtraArgs:     []string{"-d"},
+		})
+		if err := g.Cmds("print ${arg:1}"); err != nil {
+			t.Fatal(err)
+		}
+		var stdout bytes.Buffer
+		if err := g.RunWith(context.Background(), &stdout, nil); err != nil {
+			t.Fatal(err)
+		}
+		want := "arg: -d\narg: -c\narg: " + g.GpltPath() +
+			"\narg: x\nprint ARG1\n"
+		if stdout.String() != want {
+			t.Fatalf("expected %q, got %q", want, stdout.String())
+		}
+	})
+	t.Run("stdin", func(t *testing.T) {
+		g := newTestGnuPlot(t, GnuPlotOpts{GnuPlotBinary: bin, RunMode: Stdin})
+		if err := g.Cmds("set grid"); err != nil {
+			t.Fatal(err)
+		}
+		if g.GpltPath() != "" {
+			t.Fatalf("expected no gplt path, got %q", g.GpltPath())
+		}
+		var stdout bytes.Buffer
+		if err := g.RunWith(context.Background(), &stdout, nil); err != nil {
+			t.Fatal(err)
+		}
+		if stdout.String() != "set grid\n" {
+			t.Fatalf("expected %q, got %q", "set grid\n", stdout.String())
+		}
+	})
+	t.Run("stdin with args", func(t *testing.T) {
+		tmp := t.TempDir()
+		g := newTestGnuPlot(t, GnuPlotOpts{
+			GnuPlotBinary: bin,
+			RunMode:       Stdin,
+			Args:          []string{"x"},
+			TempScriptDir: tmp,
+		})
+		if err := g.Cmds("print ${arg:1}"); err != nil {
+			t.Fatal(err)
+		}
+		var stdout bytes.Buffer
+		if err := g.RunWith(context.Background(), &stdout, nil); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(stdout.String(), "\n")
+		if len(lines) != 5 || lines[0] != "arg: -c" ||
+			!strings.HasPrefix(lines[1], "arg: "+tmp) ||
+			lines[2] != "arg: x" || lines[3] != "print ARG1" {
+			t.Fatalf("unexpected output: %q", stdout.String())
+		}
+		if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+			t.Fatalf("expected the temporary script to be removed: %v", entries)
+		}
+	})
+	t.Run("in memory", func(t *testing.T) {
+		tmp := t.TempDir()
+		g := newTestGnuPlot(t, GnuPlotOpts{
+			GnuPlotBinary: bin, InMemory: true, TempScriptDir: tmp,
+		})
+		dat, err := g.DatPath(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.args = []string{dat}
+		if err := errors.Join(
+			g.DataRow(0, "1", "2"), g.Cmds("plot ${dat:0}"),
+		); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(dat); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected no dat file before running, got: %v", err)
+		}
+		var stdout bytes.Buffer
+		if err := g.RunWith(context.Background(), &stdout, nil); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(stdout.String(), "arg: "+dat+"\n1\t2\n") {
+			t.Fatalf("expected the dat file to be written: %q", stdout.String())
+		}
+		if _, err := os.Stat(dat); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected the dat file to be removed, got: %v", err)
+		}
+	})
+	t.Run("run bytes", func(t *testing.T) {
+		g := newTestGnuPlot(t, GnuPlotOpts{
+			GnuPlotBinary: bin, RunMode: Stdin, AllowEmptyOutFile: true,
+			OutFiles: []string{},
+		})
+		if err := g.Cmds("set grid"); err != nil {
+			t.Fatal(err)
+		}
+		got, err := g.RunBytes(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "set grid\n" {
+			t.Fatalf("expected %q, got %q", "set grid\n", got)
+		}
+	})
+}
+
+func TestOps(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(g *GnuPlot) error
+		want string
+	}{
+		{"col", func(g *GnuPlot) error {
+			if err := g.DataHeader(0, "x", "y"); err != nil {
+				return err
+			}
+			return g.Cmds("plot 'd' using ${col:0:y}:${col:0:x}")
+		}, "plot 'd' using 2:1\n"},
+		{"escaped", func(g *GnuPlot) error {
+			return g.Cmds("print '$${dat:0} $${nope}'")
+		}, "print '${dat:0} ${nope}'\n"},
+		{"now layout", func(g *GnuPlot) error {
+			return g.Cmds("# ${now:2006} ${now:2006}")
+		}, "# " + time.Now().Format("2006") + " " +
+			time.Now().Format("2006") + "\n"},
+		{"out", func(g *GnuPlot) error {
+			g.outFiles[0] = "out.png"
+			return g.Cmds("set output ${out} ${out:0}")
+		}, "set output 'out.png' 'out.png'\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newTestGnuPlot(t, GnuPlotOpts{})
+			if err := tc.fn(&g); err != nil {
+				t.Fatal(err)
+			}
+			got, err := g.Script()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+	t.Run("now", func(t *testing.T) {
+		g := newTestGnuPlot(t, GnuPlotOpts{})
+		before := time.Now().Truncate(time.Second)
+		if err := g.Cmds("${now}"); err != nil {
+			t.Fatal(err)
+		}
+		got, err := g.Script()
+		if err != nil {
+			t.Fatal(err)
+		}
+		now, err := time.Parse(time.RFC3339, strings.TrimSpace(got))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if now.Before(before) || now.After(time.Now()) {
+			t.Fatalf("expected the current time, got %s", now)
+		}
+	})
+	t.Run("debug log", func(t *testing.T) {
+		logged := []string{}
+		g := newTestGnuPlot(t, GnuPlotOpts{
+			DebugLog: func(s string) { logged = append(logged, s) },
+		})
+		g.datNames[0] = "data.dat"
+		if err := g.Cmds("set grid", "plot ${dat:0}"); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"set grid", "plot 'data.dat'"}
+		if !slices.Equal(logged, want) {
+			t.Fatalf("expected %q, got %q", want, logged)
+		}
+	})
+}
+
+func TestDataRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		opts GnuPlotOpts
+		fn   func(g *GnuPlot) error
+		want [][]string
+	}{
+		{"rows", GnuPlotOpts{}, func(g *GnuPlot) error {
+			return errors.Join(
+				g.DataHeader(0, "x", "y"),
+				g.DataRows(0, [][]string{{"1", "2"}, {}, {"3", "a,b"}}),
+				g.DataComment(0, "skipped"),
+			)
+		}, [][]string{{"x", "y"}, {"1", "2"}, {"3", "a,b"}}},
+		{"comment header", GnuPlotOpts{CommentHeader: true}, func(
+			g *GnuPlot,
+		) error {
+			return errors.Join(g.DataHeader(0, "x"), g.DataRowf(0, 1.5))
+		}, [][]string{{"1.5"}}},
+		{"csv sep", GnuPlotOpts{CsvSep: ','}, func(g *GnuPlot) error {
+			return g.DataRowAny(0, 1, "a\tb", true, 2.5)
+		}, [][]string{{"1", "a\tb", "1", "2.5"}}},
+		{"chan", GnuPlotOpts{}, func(g *GnuPlot) error {
+			rows := make(chan []string, ChanFlushInterval+1)
+			for i := range ChanFlushInterval + 1 {
+				rows <- []string{strconv.Itoa(i)}
+			}
+			close(rows)
+			return g.DataRowsFromChan(context.Background(), 0, rows)
+		}, func() [][]string {
+			rv := [][]string{}
+			for i := range ChanFlushInterval + 1 {
+				rv = append(rv, []string{strconv.Itoa(i)})
+			}
+			return rv
+		}()},
+		{"compressed", GnuPlotOpts{CompressDat: true}, func(
+			g *GnuPlot,
+		) error {
+			return g.DataRows(0, [][]string{{"1", "2"}, {"3", "4"}})
+		}, [][]string{{"1", "2"}, {"3", "4"}}},
+		{"structs", GnuPlotOpts{}, func(g *GnuPlot) error {
+			return g.DataStructs(0, []struct {
+				A int
+				B string
+			}{{1, "a"}, {2, "b"}}, "B", "A")
+		}, [][]string{{"a", "1"}, {"b", "2"}}},
+		{"csv reader", GnuPlotOpts{}, func(g *GnuPlot) error {
+			return g.DataFromCsvReader(
+				0, strings.NewReader("1,2\n3,4\n"), ',',
+			)
+		}, [][]string{{"1", "2"}, {"3", "4"}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newTestGnuPlot(t, tc.opts)
+			if err := tc.fn(&g); err != nil {
+				t.Fatal(err)
+			}
+			for _, stage := range []string{"open", "closed"} {
+				got, err := g.ReadDataFile(0)
+				if err != nil {
+					t.Fatalf("%s: %v", stage, err)
+				}
+				if !slices.EqualFunc(got, tc.want, slices.Equal) {
+					t.Fatalf("%s: expected %q, got %q", stage, tc.want, got)
+				}
+				if err := g.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCompressDat(t *testing.T) {
+	g := newTestGnuPlot(t, GnuPlotOpts{CompressDat: true})
+	if err := g.DataRow(0, "1", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(g.datNames[0], ".dat.gz") {
+		t.Fatalf("expected a .dat.gz file, got %s", g.datNames[0])
+	}
+	f, err := os.Open(g.datNames[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "1\t2\n" {
+		t.Fatalf("expected %q, got %q", "1\t2\n", got)
+	}
+}
+
+func TestMirrorJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(g *GnuPlot) error
+		want string
+	}{
+		{"rows", func(g *GnuPlot) error {
+			return g.DataRows(0, [][]string{{"1", "a\"b"}, {"3", "4"}})
+		}, "[\n[\"1\",\"a\\\"b\"],\n[\"3\",\"4\"]\n]\n"},
+		{"empty", func(g *GnuPlot) error {
+			return nil
+		}, "[\n]\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newTestGnuPlot(t, GnuPlotOpts{MirrorJSON: true})
+			if err := tc.fn(&g); err != nil {
+				t.Fatal(err)
+			}
+			if err := g.Close(); err != nil {
+				t.Fatal(err)
+			}
+			name := strings.TrimSuffix(g.datNames[0], ".dat") + ".json"
+			got, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+			var rows [][]string
+			if err := json.Unmarshal(got, &rows); err != nil {
+				t.Fatalf("expected valid json: %v", err)
+			}
+		})
+	}
+}
+
+func TestDataRowN(t *testing.T) {
+	tests := []struct {
+		name string
+		opts GnuPlotOpts
+		row  []string
+		want int
+	}{
+		{"tab", GnuPlotOpts{}, []string{"1", "22"}, 5},
+		{"crlf", GnuPlotOpts{UseCRLF: true}, []string{"1", "22"}, 6},
+		{"quoted", GnuPlotOpts{}, []string{"a\"b"}, 7},
+		{"limit", GnuPlotOpts{MaxDatBytes: 100}, []string{"1"}, 2},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newTestGnuPlot(t, tc.opts)
+			if err := g.DataRow(0, "first"); err != nil {
+				t.Fatal(err)
+			}
+			got, err := g.DataRowN(0, tc.row...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestAddDatFile(t *testing.T) {
+	dir := t.TempDir()
+	g := newTestGnuPlot(t, GnuPlotOpts{
+		WorkDir:  dir,
+		GpltFile: "plot",
+		DatFiles: []string{"data"},
+		OutFiles: []string{"out.png"},
+	})
+	idx, err := g.AddDatFile("extra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx != 1 {
+		t.Fatalf("expected index 1, got %d", idx)
+	}
+	path, err := g.DatPath(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(dir, "extra.dat") {
+		t.Fatalf("expected %s, got %s", filepath.Join(dir, "extra.dat"), path)
+	}
+	if err := errors.Join(
+		g.DataRow(idx, "1"), g.DataRow(idx, "2"), g.DataRow(0, "3"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if cnt, _ := g.RowCount(idx); cnt != 2 {
+		t.Fatalf("expected 2 rows, got %d", cnt)
+	}
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "1\n2\n" {
+		t.Fatalf("expected %q, got %q", "1\n2\n", got)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	dir := t.TempDir()
+	opts := GnuPlotOpts{
+		GpltFile: filepath.Join(dir, "plot"),
+		DatFiles: []string{filepath.Join(dir, "data")},
+		OutFiles: []string{filepath.Join(dir, "out.png")},
+		Append:   true,
+	}
+	for i := range 2 {
+		g, err := NewGnuPlot(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := errors.Join(
+			g.Cmds("# run "+strconv.Itoa(i)), g.DataRow(0, strconv.Itoa(i)),
+		); err != nil {
+			t.Fatal(err)
+		}
+		if err := g.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, want := range map[string]string{
+		filepath.Join(dir, "plot.gplt"): "# run 0\n# run 1\n",
+		filepath.Join(dir, "data.dat"):  "0\n1\n",
+	} {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Fatalf("%s: expected %q, got %q", path, want, got)
+		}
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	g := newTestGnuPlot(t, GnuPlotOpts{Concurrent: true})
+	const writers, rows = 8, 100
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range rows {
+				err := g.DataRow(0, strconv.Itoa(i), strconv.Itoa(j))
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if cnt, _ := g.RowCount(0); cnt != writers*rows {
+		t.Fatalf("expected %d rows, got %d", writers*rows, cnt)
+	}
+	got, err := g.ReadDataFile(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != writers*rows {
+		t.Fatalf("expected %d rows, got %d", writers*rows, len(got))
+	}
+	for _, row := range got {
+		if len(row) != 2 {
+			t.Fatalf("expected rows to not be interleaved, got %q", row)
+		}
+	}
+}
+
+func TestResetAndClearCmds(t *testing.T) {
+	t.Run("Reset", func(t *testing.T) {
+		g := newTestGnuPlot(t, GnuPlotOpts{})
+		if err := errors.Join(
+			g.Cmds("set grid"), g.DataHeader(0, "x"), g.DataRow(0, "1"),
+		); err != nil {
+			t.Fatal(err)
+		}
+		if err := g.Reset(); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := g.Script(); got != "" {
+			t.Fatalf("expected an empty script, got %q", got)
+		}
+		if cnt, _ := g.RowCount(0); cnt != 0 {
+			t.Fatalf("expected no rows, got %d", cnt)
+		}
+		if err := g.DataHeader(0, "y"); err != nil {
+			t.Fatalf("expected the header to be cleared, got: %v", err)
+		}
+		if err := g.Cmds("set key"); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := g.Script(); got != "set key\n" {
+			t.Fatalf("expected %q, got %q", "set key\n", got)
+		}
+	})
+	tests := []struct {
+		name string
+		opts GnuPlotOpts
+		want string
+	}{
+		{"file", GnuPlotOpts{}, "set key\n"},
+		{"shebang", GnuPlotOpts{Shebang: true}, shebang + "set key\n"},
+		{"stdin", GnuPlotOpts{RunMode: Stdin}, "set key\n"},
+	}
+	for _, tc := range tests {
+		t.Run("ClearCmds/"+tc.name, func(t *testing.T) {
+			g := newTestGnuPlot(t, tc.opts)
+			g.datNames[0] = "data.dat"
+			if err := g.Cmds("plot ${dat:0}", "${dat:0}"); err != nil {
+				t.Fatal(err)
+			}
+			if err := g.ClearCmds(); err != nil {
+				t.Fatal(err)
+			}
+			if err := g.Replot(); !errors.Is(err, ReplotWithoutPlotErr) {
+				t.Fatalf("expected ReplotWithoutPlotErr, got: %v", err)
+			}
+			if err := g.Cmds("set key"); err != nil {
+				t.Fatal(err)
+			}
+			got, err := g.Script()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestInlineDataScript(t *testing.T) {
+	g := newTestGnuPlot(t, GnuPlotOpts{InlineData: true, Shebang: true})
+	if _, err := g.AddDatFile("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := errors.Join(
+		g.DataRow(0, "1", "2"), g.DataRow(1, "3"), g.DataComment(1, "c"),
+		g.Cmds("plot ${dat:0}, ${dat:1}"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	want := shebang +
+		"$Data0 << EOD\n1\t2\nEOD\n" +
+		"$Data1 << EOD\n3\n# c\nEOD\n" +
+		"plot $Data0, $Data1\n"
+	for _, stage := range []string{"open", "closed"} {
+		got, err := g.Script()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("%s: expected %q, got %q", stage, want, got)
+		}
+		if err := g.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(g.GpltPath())); len(entries) != 1 {
+		t.Fatalf("expected only the gplt file to be created, got %v", entries)
+	}
+}
@@ -0,0 +1,543 @@
+package sbgnuplot
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	sberr "github.com/barbell-math/smoothbrain-errs"
+)
+
+type (
+	// The color palette used by [GnuPlot.SetPalette]. Exactly one of Name or
+	// RGBFormulae must be set.
+	Palette struct {
+		// The name of a predefined color scheme. Must be one of `gray`, `jet`,
+		// `viridis`, `hot`, or `rainbow`.
+		Name string
+		// The numbers of the three gnuplot rgb formulae that map gray values to
+		// the red, green, and blue channels. Each number must be in the range
+		// [-36, 36], where a negative number inverts the formula. Run `show
+		// palette rgbformulae` in gnuplot to see the available formulae.
+		RGBFormulae []int
+	}
+)
+
+var (
+	UnknownTerminalErr = errors.New("Unknown terminal")
+	InvalidAxisErr     = errors.New("Invalid axis")
+	InvalidRangeErr    = errors.New("Invalid range")
+	InvalidKeyPosErr   = errors.New("Invalid key position")
+	InvalidSizeErr     = errors.New("Invalid size")
+	InvalidOptionErr   = errors.New("Invalid option")
+	InvalidLogBaseErr  = errors.New("Invalid log base")
+	InvalidPauseErr    = errors.New("Invalid pause")
+	InvalidBorderErr   = errors.New("Invalid border")
+	InvalidSamplesErr  = errors.New("Invalid samples")
+	InvalidPaletteErr  = errors.New("Invalid palette")
+	InvalidMissingErr  = errors.New("Invalid missing value")
+
+	// The largest number of samples that [GnuPlot.SetSamples] will accept.
+	// This catches typos that would make gnuplot very slow.
+	MaxSamples = 100000
+	// The largest number of isosamples in either direction that
+	// [GnuPlot.SetIsosamples] will accept. This catches typos that would make
+	// gnuplot very slow.
+	MaxIsosamples = 1000
+
+	extTerminals = map[string]string{
+		".png":  "png",
+		".svg":  "svg",
+		".pdf":  "pdfcairo",
+		".eps":  "postscript eps",
+		".jpg":  "jpeg",
+		".jpeg": "jpeg",
+		".gif":  "gif",
+	}
+
+	validAxes       = []string{"x", "y", "z", "x2", "y2"}
+	validSeriesAxes = []string{"x1y1", "x1y2", "x2y1", "x2y2"}
+	validKeyPos     = []string{
+		"on", "off", "default", "inside", "outside", "left", "right", "center",
+		"top", "bottom", "lmargin", "rmargin", "tmargin", "bmargin", "above",
+		"over", "below", "under",
+	}
+	paletteSchemes = map[string]string{
+		"gray":    "gray",
+		"viridis": "viridis",
+		"hot":     "rgbformulae 21,22,23",
+		"rainbow": "rgbformulae 33,13,10",
+		"jet": "defined (0 '#000090', 1 '#000fff', 2 '#0090ff', " +
+			"3 '#0fffee', 4 '#90ff70', 5 '#ffee00', 6 '#ff7000', " +
+			"7 '#ee0000', 8 '#7f0000')",
+	}
+)
+
+// Writes a set cmd for the supplied option with the supplied args separated by
+// spaces, as shown below. The cmd is passed to [GnuPlot.Cmds] so any ops in
+// the option or args will be resolved.
+//
+//	set <option> <args>
+//
+// The args are not quoted, so strings should be quoted by the caller. If the
+// option is empty or contains whitespace a [InvalidOptionErr] will be returned.
+func (g *GnuPlot) Set(option string, args ...string) error {
+	if option == "" || strings.ContainsFunc(option, unicode.IsSpace) {
+		return sberr.Wrap(
+			InvalidOptionErr,
+			"Option must be a single non-empty word: Got: %q", option,
+		)
+	}
+	return g.Cmds(strings.Join(append([]string{"set", option}, args...), " "))
+}
+
+// Writes the cmd that tells gnuplot to split the columns of the dat files on
+// the configured [GnuPlotOpts.CsvSep], keeping the separator that is used to
+// write the data and the separator that is used to read it in sync. The
+// following cmd will be written, where tabs and commas are written using
+// gnuplot's `tab` and `comma` keywords and all other separators are quoted:
+//
+//	set datafile separator <separator>
+func (g *GnuPlot) SetDatafileSeparator() error {
+	var sep string
+	switch r := g.opts.CsvSep; r {
+	case '\t':
+		sep = "tab"
+	case ',':
+		sep = "comma"
+	default:
+		sep = quote(string(r))
+	}
+	return g.Cmds("set datafile separator " + sep)
+}
+
+// Writes the cmd that tells gnuplot to treat the supplied token as missing
+// data, so gnuplot leaves a gap instead of plotting the value. The following
+// cmd will be written:
+//
+//	set datafile missing '<token>'
+//
+// If the token is empty the configured [GnuPlotOpts.MissingValue] will be
+// used, which is the token NaN and infinite floats are written as. If the
+// token is not empty it must match [GnuPlotOpts.MissingValue], otherwise a
+// [InvalidMissingErr] will be returned, so the token that is written and the
+// token gnuplot treats as missing always agree.
+func (g *GnuPlot) SetDatafileMissing(token string) error {
+	if token == "" {
+		token = g.opts.MissingValue
+	}
+	if token != g.opts.MissingValue {
+		return sberr.Wrap(
+			InvalidMissingErr,
+			"Token does not match MissingValue: Got: %q Expected: %q",
+			token, g.opts.MissingValue,
+		)
+	}
+	return g.Cmds("set datafile missing " + quote(token))
+}
+
+// Writes the cmd that sets the title of the plot. The title is escaped so that
+// it can contain any characters, including single quotes, double quotes,
+// backslashes, and newlines. The following cmd will be written:
+//
+//	set title '<title>'
+//
+// If the title contains a newline it will instead be written as a double
+// quoted string with backslashes, double quotes, and newlines escaped, because
+// gnuplot does not process escape sequences in single quoted strings. Any `${`
+// in the title is escaped so it is written literally rather than being
+// resolved as an op.
+func (g *GnuPlot) SetTitle(title string) error {
+	return g.Cmds("set title " + escapeOps(quoteText(title)))
+}
+
+// Writes the cmd that sets the title of the plot to a title derived from the
+// name of the first out file. The directory and extension are removed and any
+// underscores, dashes, or dots are replaced with spaces, so an out file of
+// `plots/cpu_usage-2024.png` results in the following cmd:
+//
+//	set title 'cpu usage 2024'
+//
+// If there are no out files a [InvalidOutIndexErr] will be returned and if the
+// first out file is empty, or results in an empty title, a [EmptyOutFileErr]
+// will be returned.
+func (g *GnuPlot) TitleFromOutFile() error {
+	if err := g.checkOutIdx(0); err != nil {
+		return err
+	}
+	base := filepath.Base(g.outFiles[0])
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	title := strings.Join(strings.FieldsFunc(base, func(r rune) bool {
+		return r == '_' || r == '-' || r == '.' || unicode.IsSpace(r)
+	}), " ")
+	if g.outFiles[0] == "" || title == "" {
+		return sberr.Wrap(
+			EmptyOutFileErr,
+			"Could not derive a title from the out file: Got: %q",
+			g.outFiles[0],
+		)
+	}
+	return g.SetTitle(title)
+}
+
+// Quotes the supplied text, using a double quoted string if the text contains
+// newlines so that they can be escaped.
+func quoteText(s string) string {
+	if !strings.ContainsAny(s, "\r\n") {
+		return quote(s)
+	}
+	return "\"" + strings.NewReplacer(
+		"\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\r", "\\r",
+	).Replace(s) + "\""
+}
+
+// Writes the cmds that set the terminal and the output file to the gnu plot
+// code file. The following cmds will be written:
+//
+//	set terminal <terminal> size <width>,<height> <opts>
+//	set output '<first out file>'
+//
+// If `terminal` is an empty string the terminal will be picked based on the
+// extension of the first out file using [TerminalForExt]. The size will only
+// be added if it was set with [GnuPlot.SetSize]. If there are no out files a
+// [InvalidOutIndexErr] will be returned.
+func (g *GnuPlot) SetOutput(terminal string, opts ...string) error {
+	if err := g.checkOutIdx(0); err != nil {
+		return err
+	}
+	if terminal == "" {
+		var err error
+		if terminal, err = TerminalForExt(g.outFiles[0]); err != nil {
+			return err
+		}
+	}
+	terminalCmd := []string{"set terminal", terminal}
+	if g.width > 0 && g.height > 0 {
+		terminalCmd = append(
+			terminalCmd, fmt.Sprintf("size %d,%d", g.width, g.height),
+		)
+	}
+	return g.Cmds(
+		strings.Join(append(terminalCmd, opts...), " "),
+		"set output ${out}",
+	)
+}
+
+// Sets the size of the generated plot that will be added to the terminal cmd
+// written by [GnuPlot.SetOutput]. The size only applies to calls to
+// [GnuPlot.SetOutput] that are made after calling this method, so when using
+// [GnuPlotOpts.AutoTerminal] [GnuPlot.SetOutput] will need to be called again.
+// The units of the size depend on the terminal, for example pixels for png and
+// inches for pdfcairo. If either dimension is not positive a [InvalidSizeErr]
+// will be returned.
+func (g *GnuPlot) SetSize(width, height int) error {
+	if width <= 0 || height <= 0 {
+		return sberr.Wrap(
+			InvalidSizeErr,
+			"Width and height must be positive: Got: %d,%d", width, height,
+		)
+	}
+	g.width = width
+	g.height = height
+	return nil
+}
+
+// Returns the gnuplot terminal that is typically used to generate files with
+// the extension of the supplied path. The following extensions are recognized:
+//
+//   - .png: png
+//   - .svg: svg
+//   - .pdf: pdfcairo
+//   - .eps: postscript eps
+//   - .jpg, .jpeg: jpeg
+//   - .gif: gif
+//
+// If the extension is not recognized a [UnknownTerminalErr] will be returned.
+func TerminalForExt(path string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	terminal, ok := extTerminals[ext]
+	if !ok {
+		return "", sberr.Wrap(
+			UnknownTerminalErr,
+			"Could not determine terminal from file extension: %s", path,
+		)
+	}
+	return terminal, nil
+}
+
+// Writes the cmd that sets the range of the supplied axis. The axis must be one
+// of `x`, `y`, `z`, `x2`, or `y2`, otherwise a [InvalidAxisErr] will be
+// returned. Either end of the range can be left open, allowing gnuplot to
+// autoscale it, by supplying an infinite value for that end:
+//
+//	g.SetRange("x", 0, math.Inf(1)) // set xrange [0:*]
+//
+// If either value is NaN a [InvalidRangeErr] will be returned.
+func (g *GnuPlot) SetRange(axis string, min, max float64) error {
+	if err := checkAxis(axis); err != nil {
+		return err
+	}
+	if math.IsNaN(min) || math.IsNaN(max) {
+		return sberr.Wrap(
+			InvalidRangeErr, "Range cannot contain NaN: Got: [%f:%f]", min, max,
+		)
+	}
+	return g.Cmds(fmt.Sprintf(
+		"set %srange [%s:%s]", axis, formatRangeVal(min), formatRangeVal(max),
+	))
+}
+
+// Writes the cmds that enable the secondary y axis so that series can be drawn
+// against it by setting [Series.Axes] to `x1y2`. The following cmds will be
+// written, with the label cmd being left out if `label` is empty:
+//
+//	set ytics nomirror
+//	set y2tics
+//	set y2label '<label>'
+//
+// Any `${` in the label is escaped so it is written literally rather than being
+// resolved as an op.
+func (g *GnuPlot) EnableY2(label string) error {
+	cmds := []string{"set ytics nomirror", "set y2tics"}
+	if label != "" {
+		cmds = append(cmds, "set y2label "+escapeOps(quoteText(label)))
+	}
+	return g.Cmds(cmds...)
+}
+
+// Writes the cmd that sets the position of the key, also known as the legend.
+// The following cmd will be written:
+//
+//	set key <position> <opts>
+//
+// The position is made up of space separated words that must each be one of
+// `on`, `off`, `default`, `inside`, `outside`, `left`, `right`, `center`,
+// `top`, `bottom`, `lmargin`, `rmargin`, `tmargin`, `bmargin`, `above`,
+// `over`, `below`, or `under`, such as `top left`. If the position is empty or
+// contains any other word a [InvalidKeyPosErr] will be returned. The opts are
+// not validated and can be used for any other key settings, such as `box`.
+func (g *GnuPlot) SetKey(position string, opts ...string) error {
+	words := strings.Fields(position)
+	if len(words) == 0 {
+		return sberr.Wrap(InvalidKeyPosErr, "Position cannot be empty")
+	}
+	for _, w := range words {
+		if !slices.Contains(validKeyPos, w) {
+			return sberr.Wrap(
+				InvalidKeyPosErr, "Got: %s Allowed: %v", w, validKeyPos,
+			)
+		}
+	}
+	return g.Cmds(strings.Join(
+		append([]string{"set key", strings.Join(words, " ")}, opts...), " ",
+	))
+}
+
+// Writes the cmd that makes the supplied axis use a logarithmic scale with the
+// supplied base. The following cmd will be written:
+//
+//	set logscale <axis> <base>
+//
+// The axis must be one of `x`, `y`, `z`, `x2`, or `y2`, otherwise a
+// [InvalidAxisErr] will be returned. If the base is not greater than one a
+// [InvalidLogBaseErr] will be returned.
+func (g *GnuPlot) SetLogScale(axis string, base int) error {
+	if err := checkAxis(axis); err != nil {
+		return err
+	}
+	if base <= 1 {
+		return sberr.Wrap(
+			InvalidLogBaseErr, "Base must be greater than one: Got: %d", base,
+		)
+	}
+	return g.Cmds(fmt.Sprintf("set logscale %s %d", axis, base))
+}
+
+// Writes the cmd that makes the supplied axis use a linear scale again after
+// calling [GnuPlot.SetLogScale]. The following cmd will be written:
+//
+//	unset logscale <axis>
+//
+// The axis must be one of `x`, `y`, `z`, `x2`, or `y2`, otherwise a
+// [InvalidAxisErr] will be returned.
+func (g *GnuPlot) UnsetLogScale(axis string) error {
+	if err := checkAxis(axis); err != nil {
+		return err
+	}
+	return g.Cmds("unset logscale " + axis)
+}
+
+// Writes the cmd that pauses gnuplot for the supplied number of seconds, which
+// can be combined with repeated plot cmds to create simple animations. The
+// following cmd will be written:
+//
+//	pause <seconds>
+//
+// If seconds is negative, infinite, or NaN a [InvalidPauseErr] will be
+// returned.
+func (g *GnuPlot) Pause(seconds float64) error {
+	if seconds < 0 || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+		return sberr.Wrap(
+			InvalidPauseErr,
+			"Seconds must be a non-negative finite number: Got: %f", seconds,
+		)
+	}
+	return g.Cmds("pause " + strconv.FormatFloat(seconds, 'g', -1, 64))
+}
+
+// Writes the cmd that pauses gnuplot until a mouse click or key press is made
+// in the plot window. The following cmd will be written:
+//
+//	pause mouse
+func (g *GnuPlot) PauseMouse() error {
+	return g.Cmds("pause mouse")
+}
+
+// Writes the cmd that draws grid lines at the tic marks. The following cmd
+// will be written:
+//
+//	set grid <opts>
+//
+// The opts are not validated and can be used to select the tics and the style
+// of the grid lines, such as `xtics`, `ytics`, or `linestyle 1`.
+func (g *GnuPlot) SetGrid(opts ...string) error {
+	return g.Cmds(strings.Join(append([]string{"set grid"}, opts...), " "))
+}
+
+// Writes the cmd that stops drawing the grid lines enabled by
+// [GnuPlot.SetGrid]. The following cmd will be written:
+//
+//	unset grid
+func (g *GnuPlot) UnsetGrid() error {
+	return g.Cmds("unset grid")
+}
+
+// Writes the cmd that selects which sides of the plot have a border drawn. The
+// mask is a bit mask of the sides, for example 3 draws the bottom and left
+// sides of a 2D plot. The following cmd will be written:
+//
+//	set border <mask>
+//
+// If the mask is not in the range [0, 4095] a [InvalidBorderErr] will be
+// returned.
+func (g *GnuPlot) SetBorder(mask int) error {
+	if mask < 0 || mask > 4095 {
+		return sberr.Wrap(
+			InvalidBorderErr,
+			"Mask out of range: Got: %d Allowed Range: [0, 4095]", mask,
+		)
+	}
+	return g.Cmds(fmt.Sprintf("set border %d", mask))
+}
+
+// Writes the cmd that sets the number of points functions are sampled at,
+// which controls how smooth they are drawn. The following cmd will be
+// written:
+//
+//	set samples <n>
+//
+// If n is not in the range [1, [MaxSamples]] a [InvalidSamplesErr] will be
+// returned.
+func (g *GnuPlot) SetSamples(n int) error {
+	if err := checkSamples(n, MaxSamples); err != nil {
+		return err
+	}
+	return g.Cmds(fmt.Sprintf("set samples %d", n))
+}
+
+// Writes the cmd that sets the number of isolines used to draw surfaces, which
+// controls how dense the mesh of the surface is. The following cmd will be
+// written:
+//
+//	set isosamples <u>,<v>
+//
+// If u or v are not in the range [1, [MaxIsosamples]] a [InvalidSamplesErr]
+// will be returned.
+func (g *GnuPlot) SetIsosamples(u, v int) error {
+	if err := checkSamples(u, MaxIsosamples); err != nil {
+		return err
+	}
+	if err := checkSamples(v, MaxIsosamples); err != nil {
+		return err
+	}
+	return g.Cmds(fmt.Sprintf("set isosamples %d,%d", u, v))
+}
+
+// Writes the cmd that sets the color palette used by heatmaps, pm3d surfaces,
+// and other plots that color by value. A named scheme will write the cmd that
+// defines that scheme and rgb formulae will write the following cmd:
+//
+//	set palette rgbformulae <r>,<g>,<b>
+//
+// A [InvalidPaletteErr] will be returned if both or neither of the name and
+// rgb formulae are set, if the name is not a known scheme, or if the rgb
+// formulae are not three numbers in the range [-36, 36]. Note that the
+// `viridis` scheme requires gnuplot 5.4 or newer.
+func (g *GnuPlot) SetPalette(p Palette) error {
+	if (p.Name == "") == (p.RGBFormulae == nil) {
+		return sberr.Wrap(
+			InvalidPaletteErr, "Exactly one of Name or RGBFormulae must be set",
+		)
+	}
+	if p.Name != "" {
+		scheme, ok := paletteSchemes[p.Name]
+		if !ok {
+			return sberr.Wrap(
+				InvalidPaletteErr,
+				"Unknown scheme: Got: %s Allowed: %v",
+				p.Name, slices.Sorted(maps.Keys(paletteSchemes)),
+			)
+		}
+		return g.Cmds("set palette " + scheme)
+	}
+	if len(p.RGBFormulae) != 3 {
+		return sberr.Wrap(
+			InvalidPaletteErr,
+			"Expected 3 rgb formulae: Got: %d", len(p.RGBFormulae),
+		)
+	}
+	for _, f := range p.RGBFormulae {
+		if f < -36 || f > 36 {
+			return sberr.Wrap(
+				InvalidPaletteErr,
+				"Rgb formula out of range: Got: %d Allowed Range: [-36, 36]", f,
+			)
+		}
+	}
+	return g.Cmds(fmt.Sprintf(
+		"set palette rgbformulae %d,%d,%d",
+		p.RGBFormulae[0], p.RGBFormulae[1], p.RGBFormulae[2],
+	))
+}
+
+func checkSamples(n int, max int) error {
+	if n < 1 || n > max {
+		return sberr.Wrap(
+			InvalidSamplesErr,
+			"Samples out of range: Got: %d Allowed Range: [1, %d]", n, max,
+		)
+	}
+	return nil
+}
+
+func formatRangeVal(v float64) string {
+	if math.IsInf(v, 0) {
+		return "*"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func checkAxis(axis string) error {
+	if !slices.Contains(validAxes, axis) {
+		return sberr.Wrap(
+			InvalidAxisErr, "Got: %s Allowed: %v", axis, validAxes,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,603 @@
+package sbgnuplot
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	sberr "github.com/barbell-math/smoothbrain-errs"
+)
+
+type (
+	// A builder that emits the cmds for common plots. A plot builder is
+	// created with [GnuPlot.PlotBuilder] and the cmds are only written to the
+	// gnu plot code file once [PlotBuilder.Build] is called. Raw cmds can
+	// still be written with [GnuPlot.Cmds] before or after building the plot
+	// for anything the builder does not support.
+	PlotBuilder struct {
+		g      *GnuPlot
+		title  string
+		xLabel string
+		yLabel string
+		lines  []Series
+	}
+
+	// A single series of a plot cmd, as used by [GnuPlot.PlotSeries].
+	Series struct {
+		// The index of the data file that the series will be drawn from.
+		DatIdx int
+		// The gnuplot using specification, such as `1:2`. If empty gnuplot's
+		// default columns will be used.
+		Using string
+		// The title of the series that will be shown in the key. If empty
+		// gnuplot's default title will be used. Any `${` in the title is
+		// escaped so it is written literally rather than being resolved as an
+		// op.
+		Title string
+		// The gnuplot style the series will be drawn with, such as `lines` or
+		// `points`. If empty gnuplot's default style will be used.
+		Style string
+		// The axes the series will be drawn against, such as `x1y2` to use
+		// the secondary y axis enabled by [GnuPlot.EnableY2]. Must be one of
+		// `x1y1`, `x1y2`, `x2y1`, or `x2y2`. If empty gnuplot's default axes
+		// will be used.
+		Axes string
+		// The id of the line style, defined with [GnuPlot.DefineLineStyle],
+		// that the series will be drawn with. If zero no line style will be
+		// used.
+		LineStyle int
+		// The 1-based column of the data file that contains the labels of the
+		// x tics, as used by categorical plots such as bar charts. The column
+		// will be added to the using specification as `:xtic(<column>)` so
+		// [Series.Using] must also be set. If zero no tic labels will be read.
+		XTicColumn int
+		// When true the data file will be read as a matrix of values, such as
+		// the data written by [GnuPlot.DataMatrix], by adding the `matrix`
+		// keyword after the data file. This is typically used with
+		// [GnuPlot.Splot] to draw surfaces or heatmaps.
+		Matrix bool
+	}
+
+	// The options of a line style that is defined with
+	// [GnuPlot.DefineLineStyle]. Any fields that are left as the zero value
+	// will use gnuplot's defaults.
+	LineStyle struct {
+		// The color of the line, such as `#ff0000` or `red`.
+		Color string
+		// The width of the line.
+		Width float64
+		// The dash type of the line. This is written as is, so it can either
+		// be a dash type number such as `2` or a quoted pattern such as
+		// `'-.'`.
+		Dash string
+		// The type of the points drawn by styles that include points.
+		PointType int
+		// The size of the points drawn by styles that include points.
+		PointSize float64
+	}
+
+	// The options that control how a histogram is drawn by
+	// [GnuPlot.Histogram].
+	HistogramOpts struct {
+		// The width of each bin. Must be greater than zero.
+		BinWidth float64
+		// The 1-based column of the data file that contains the values that
+		// will be binned. Defaults to 1 when left as zero.
+		Column int
+		// The gnuplot fill style of the boxes, such as `solid 0.5` or
+		// `pattern 2`. Defaults to `solid 0.5` when empty.
+		FillStyle string
+		// The title of the plot. No title will be set when empty.
+		Title string
+		// The title of the histogram series that will be shown in the key. The
+		// series will not be shown in the key when empty.
+		SeriesTitle string
+	}
+)
+
+var (
+	EmptyPlotErr            = errors.New("Empty plot")
+	InvalidHistogramOptsErr = errors.New("Invalid histogram opts")
+	MultiplotErr            = errors.New("Multiplot error")
+	InvalidFitErr           = errors.New("Invalid fit")
+	InvalidLineStyleErr     = errors.New("Invalid line style")
+	InvalidSeriesErr        = errors.New("Invalid series")
+	InvalidFuncErr          = errors.New("Invalid func")
+	InvalidViewErr          = errors.New("Invalid view")
+	ReplotWithoutPlotErr    = errors.New("Replot without plot")
+	InvalidVarErr           = errors.New("Invalid var")
+
+	identRegex   = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+	funcSigRegex = regexp.MustCompile(
+		"^[A-Za-z_][A-Za-z0-9_]*\\(\\s*[A-Za-z_][A-Za-z0-9_]*" +
+			"(\\s*,\\s*[A-Za-z_][A-Za-z0-9_]*)*\\s*\\)$",
+	)
+)
+
+// Creates a new [PlotBuilder] that will write its cmds to the gnu plot code
+// file of this [GnuPlot].
+func (g *GnuPlot) PlotBuilder() *PlotBuilder {
+	return &PlotBuilder{g: g}
+}
+
+// Sets the title of the plot.
+func (p *PlotBuilder) Title(title string) *PlotBuilder {
+	p.title = title
+	return p
+}
+
+// Sets the label of the x axis.
+func (p *PlotBuilder) XLabel(label string) *PlotBuilder {
+	p.xLabel = label
+	return p
+}
+
+// Sets the label of the y axis.
+func (p *PlotBuilder) YLabel(label string) *PlotBuilder {
+	p.yLabel = label
+	return p
+}
+
+// Adds a line to the plot that will be drawn with the data from the data file
+// at the supplied index. The `using` string is the gnuplot using specification,
+// such as `1:2`. If `using` is empty gnuplot's default columns will be used.
+func (p *PlotBuilder) Line(datIndex int, using string) *PlotBuilder {
+	p.lines = append(
+		p.lines, Series{DatIdx: datIndex, Using: using, Style: "lines"},
+	)
+	return p
+}
+
+// Writes the cmds for the plot to the gnu plot code file. The title and labels
+// are set first, followed by a single plot cmd containing all of the lines. If
+// no lines were added a [EmptyPlotErr] will be returned. If any of the lines
+// reference an invalid data file a [InvalidDatIndexErr] will be returned. No
+// cmds will be written if an error is returned. Any `${` in the title or labels
+// is escaped so it is written literally rather than being resolved as an op.
+func (p *PlotBuilder) Build() error {
+	cmds := []string{}
+	if p.title != "" {
+		cmds = append(cmds, "set title "+escapeOps(quoteText(p.title)))
+	}
+	if p.xLabel != "" {
+		cmds = append(cmds, "set xlabel "+escapeOps(quoteText(p.xLabel)))
+	}
+	if p.yLabel != "" {
+		cmds = append(cmds, "set ylabel "+escapeOps(quoteText(p.yLabel)))
+	}
+
+	plot, err := p.g.seriesCmd("plot", p.lines)
+	if err != nil {
+		return err
+	}
+	return p.g.Cmds(append(cmds, plot)...)
+}
+
+// Writes a single plot cmd containing all of the supplied series to the gnu
+// plot code file. Each series will be written as follows, with any empty
+// fields of the series being left out:
+//
+//	'<dat file>' matrix using <using>:xtic(<xtic column>) axes <axes> with <style> linestyle <id> title '<title>'
+//
+// If no series were supplied a [EmptyPlotErr] will be returned. If any of the
+// series reference an invalid data file a [InvalidDatIndexErr] will be
+// returned, if any of the series have invalid axes a [InvalidAxisErr] will be
+// returned, if any of the series have a negative line style a
+// [InvalidLineStyleErr] will be returned, and if any of the series have an
+// invalid xtic column a [InvalidSeriesErr] will be returned. No cmds will be
+// written if an error is returned.
+func (g *GnuPlot) PlotSeries(series ...Series) error {
+	plot, err := g.seriesCmd("plot", series)
+	if err != nil {
+		return err
+	}
+	return g.Cmds(plot)
+}
+
+// Writes a single replot cmd that adds all of the supplied series to the
+// previous plot. Each series is written in the same way as
+// [GnuPlot.PlotSeries]. If no `plot` or `splot` cmd has been written yet a
+// [ReplotWithoutPlotErr] will be returned, otherwise the same errors as
+// [GnuPlot.PlotSeries] will be returned. Note that gnuplot redraws the entire
+// plot for each replot cmd, so when writing to a file only the output of the
+// last replot will be kept.
+func (g *GnuPlot) Replot(series ...Series) error {
+	if !g.plotted {
+		return sberr.Wrap(
+			ReplotWithoutPlotErr,
+			"A plot or splot cmd must be written before replotting",
+		)
+	}
+	plot, err := g.seriesCmd("replot", series)
+	if err != nil {
+		return err
+	}
+	return g.Cmds(plot)
+}
+
+// Writes a single splot cmd containing all of the supplied series to the gnu
+// plot code file, drawing them as 3D plots. Each series is written in the same
+// way as [GnuPlot.PlotSeries]. To draw a surface from the data written by
+// [GnuPlot.DataMatrix] set [Series.Matrix] to true. The same errors as
+// [GnuPlot.PlotSeries] will be returned.
+func (g *GnuPlot) Splot(series ...Series) error {
+	plot, err := g.seriesCmd("splot", series)
+	if err != nil {
+		return err
+	}
+	return g.Cmds(plot)
+}
+
+// Writes the cmd that sets the angle 3D plots are viewed from, in degrees. The
+// following cmd will be written:
+//
+//	set view <rot x>,<rot z>
+//
+// If either angle is not in the range [0, 360] a [InvalidViewErr] will be
+// returned.
+func (g *GnuPlot) SetView(rotX, rotZ float64) error {
+	if !(rotX >= 0 && rotX <= 360) || !(rotZ >= 0 && rotZ <= 360) {
+		return sberr.Wrap(
+			InvalidViewErr,
+			"Angles out of range: Got: %f,%f Allowed Range: [0, 360]",
+			rotX, rotZ,
+		)
+	}
+	return g.Cmds(fmt.Sprintf(
+		"set view %s,%s",
+		strconv.FormatFloat(rotX, 'g', -1, 64),
+		strconv.FormatFloat(rotZ, 'g', -1, 64),
+	))
+}
+
+// Writes a single plot cmd that draws all of the supplied function
+// expressions, such as `sin(x)` or `f(x) title 'fit'`, without referencing any
+// data files. The following cmd will be written:
+//
+//	plot <expr 1>, <expr 2>, ...
+//
+// If no expressions were supplied a [EmptyPlotErr] will be returned and if any
+// of the expressions are empty a [InvalidSeriesErr] will be returned.
+func (g *GnuPlot) PlotFunc(exprs ...string) error {
+	if len(exprs) == 0 {
+		return EmptyPlotErr
+	}
+	for i, e := range exprs {
+		if strings.TrimSpace(e) == "" {
+			return sberr.Wrap(InvalidSeriesErr, "Expression %d was empty", i)
+		}
+	}
+	return g.Cmds("plot " + strings.Join(exprs, ", "))
+}
+
+// Creates a cmd of the form `<cmd> <series 1>, <series 2>, ...`.
+func (g *GnuPlot) seriesCmd(cmd string, series []Series) (string, error) {
+	if len(series) == 0 {
+		return "", EmptyPlotErr
+	}
+	parts := make([]string, len(series))
+	for i, iterS := range series {
+		if err := g.checkDatIdx(iterS.DatIdx); err != nil {
+			return "", err
+		}
+		parts[i] = fmt.Sprintf("${dat:%d}", iterS.DatIdx)
+		if iterS.Matrix {
+			parts[i] += " matrix"
+		}
+		if iterS.Using != "" {
+			parts[i] += " using " + iterS.Using
+		}
+		if iterS.XTicColumn < 0 {
+			return "", sberr.Wrap(
+				InvalidSeriesErr,
+				"XTicColumn must not be negative: Got: %d", iterS.XTicColumn,
+			)
+		}
+		if iterS.XTicColumn > 0 {
+			if iterS.Using == "" {
+				return "", sberr.Wrap(
+					InvalidSeriesErr, "XTicColumn requires Using to be set",
+				)
+			}
+			parts[i] += fmt.Sprintf(":xtic(%d)", iterS.XTicColumn)
+		}
+		if iterS.Axes != "" {
+			if !slices.Contains(validSeriesAxes, iterS.Axes) {
+				return "", sberr.Wrap(
+					InvalidAxisErr,
+					"Got: %s Allowed: %v", iterS.Axes, validSeriesAxes,
+				)
+			}
+			parts[i] += " axes " + iterS.Axes
+		}
+		if iterS.Style != "" {
+			parts[i] += " with " + iterS.Style
+		}
+		if iterS.LineStyle < 0 {
+			return "", sberr.Wrap(
+				InvalidLineStyleErr,
+				"Line style id must not be negative: Got: %d", iterS.LineStyle,
+			)
+		}
+		if iterS.LineStyle > 0 {
+			parts[i] += fmt.Sprintf(" linestyle %d", iterS.LineStyle)
+		}
+		if iterS.Title != "" {
+			parts[i] += " title " + escapeOps(quoteText(iterS.Title))
+		}
+	}
+	return cmd + " " + strings.Join(parts, ", "), nil
+}
+
+// Writes the cmds that plot a histogram of the values in the data file at the
+// supplied index. The values are placed into bins of [HistogramOpts.BinWidth]
+// and the number of values in each bin is drawn as a box. The following cmds
+// will be written:
+//
+//	set title '<title>'
+//	set style fill <fill style>
+//	set boxwidth <bin width> absolute
+//	histogram_bin(x) = <bin width>*floor(x/<bin width>)+<bin width>/2.0
+//	plot '<dat file>' using (histogram_bin($<column>)):(1.0) smooth freq with boxes title '<series title>'
+//
+// If the index is invalid a [InvalidDatIndexErr] will be returned. If the bin
+// width is not greater than zero or the column is negative a
+// [InvalidHistogramOptsErr] will be returned. Any `${` in the titles is escaped
+// so it is written literally rather than being resolved as an op.
+func (g *GnuPlot) Histogram(datIndex int, opts HistogramOpts) error {
+	if err := g.checkDatIdx(datIndex); err != nil {
+		return err
+	}
+	if opts.BinWidth <= 0 {
+		return sberr.Wrap(
+			InvalidHistogramOptsErr,
+			"Bin width must be greater than zero: Got: %f", opts.BinWidth,
+		)
+	}
+	if opts.Column < 0 {
+		return sberr.Wrap(
+			InvalidHistogramOptsErr,
+			"Column must not be negative: Got: %d", opts.Column,
+		)
+	}
+	if opts.Column == 0 {
+		opts.Column = 1
+	}
+	if opts.FillStyle == "" {
+		opts.FillStyle = "solid 0.5"
+	}
+
+	cmds := []string{}
+	if opts.Title != "" {
+		cmds = append(cmds, "set title "+escapeOps(quoteText(opts.Title)))
+	}
+	width := strconv.FormatFloat(opts.BinWidth, 'g', -1, 64)
+	title := "notitle"
+	if opts.SeriesTitle != "" {
+		title = "title " + escapeOps(quoteText(opts.SeriesTitle))
+	}
+	cmds = append(
+		cmds,
+		"set style fill "+opts.FillStyle,
+		fmt.Sprintf("set boxwidth %s absolute", width),
+		fmt.Sprintf(
+			"histogram_bin(x) = %s*floor(x/%s)+%s/2.0", width, width, width,
+		),
+		fmt.Sprintf(
+			"plot ${dat:%d} using (histogram_bin($%d)):(1.0) smooth freq with boxes %s",
+			datIndex, opts.Column, title,
+		),
+	)
+	return g.Cmds(cmds...)
+}
+
+// Writes the cmd that starts a multiplot with the supplied layout. All plots
+// that are drawn until [GnuPlot.EndMultiplot] is called will be placed in the
+// grid, filling it row by row. The following cmd will be written, with the
+// title being left out if it is empty:
+//
+//	set multiplot layout <rows>,<cols> title '<title>'
+//
+// If rows or cols are not positive, or a multiplot has already been started
+// and not ended, a [MultiplotErr] will be returned. Any `${` in the title is
+// escaped so it is written literally rather than being resolved as an op.
+func (g *GnuPlot) BeginMultiplot(rows, cols int, title string) error {
+	if g.inMultiplot {
+		return sberr.Wrap(
+			MultiplotErr, "A multiplot was already started and not ended",
+		)
+	}
+	if rows <= 0 || cols <= 0 {
+		return sberr.Wrap(
+			MultiplotErr,
+			"Rows and cols must be positive: Got: %d,%d", rows, cols,
+		)
+	}
+	cmd := fmt.Sprintf("set multiplot layout %d,%d", rows, cols)
+	if title != "" {
+		cmd += " title " + escapeOps(quoteText(title))
+	}
+	if err := g.Cmds(cmd); err != nil {
+		return err
+	}
+	g.inMultiplot = true
+	return nil
+}
+
+// Writes the cmd that ends the multiplot started by [GnuPlot.BeginMultiplot].
+// If no multiplot was started a [MultiplotErr] will be returned.
+func (g *GnuPlot) EndMultiplot() error {
+	if !g.inMultiplot {
+		return sberr.Wrap(MultiplotErr, "No multiplot was started")
+	}
+	if err := g.Cmds("unset multiplot"); err != nil {
+		return err
+	}
+	g.inMultiplot = false
+	return nil
+}
+
+// Writes the cmd that fits the supplied function to the data in the data file
+// at the supplied index by adjusting the supplied vars. Once gnuplot has run
+// the fit the vars will hold the fitted values so they can be used by any
+// later cmds, such as a plot cmd that draws the fitted function. The following
+// cmd will be written, with the using specification being left out if it is
+// empty:
+//
+//	fit <func expr> '<dat file>' using <using> via <var 1>,<var 2>,...
+//
+// If the index is invalid a [InvalidDatIndexErr] will be returned. If the
+// function expression is empty, no vars were supplied, or any of the vars are
+// not valid gnuplot variable names a [InvalidFitErr] will be returned.
+func (g *GnuPlot) Fit(
+	datIndex int,
+	funcExpr string,
+	using string,
+	vars ...string,
+) error {
+	if err := g.checkDatIdx(datIndex); err != nil {
+		return err
+	}
+	if strings.TrimSpace(funcExpr) == "" {
+		return sberr.Wrap(InvalidFitErr, "The function expression was empty")
+	}
+	if len(vars) == 0 {
+		return sberr.Wrap(InvalidFitErr, "At least one var must be supplied")
+	}
+	for _, v := range vars {
+		if !identRegex.MatchString(v) {
+			return sberr.Wrap(InvalidFitErr, "Invalid var name: Got: %q", v)
+		}
+	}
+
+	cmd := fmt.Sprintf("fit %s ${dat:%d}", funcExpr, datIndex)
+	if using != "" {
+		cmd += " using " + using
+	}
+	return g.Cmds(cmd + " via " + strings.Join(vars, ","))
+}
+
+// Writes the cmd that defines a line style with the supplied id, allowing
+// series to reference it by setting [Series.LineStyle]. The following cmd will
+// be written, with any options that were left as the zero value being left out:
+//
+//	set style line <id> linecolor rgb '<color>' linewidth <width> dashtype <dash> pointtype <point type> pointsize <point size>
+//
+// If the id is not positive or any of the numeric options are negative a
+// [InvalidLineStyleErr] will be returned. Any `${` in the color is escaped so
+// it is written literally rather than being resolved as an op.
+func (g *GnuPlot) DefineLineStyle(id int, style LineStyle) error {
+	if id <= 0 {
+		return sberr.Wrap(
+			InvalidLineStyleErr, "Id must be positive: Got: %d", id,
+		)
+	}
+	if style.Width < 0 || style.PointType < 0 || style.PointSize < 0 {
+		return sberr.Wrap(
+			InvalidLineStyleErr,
+			"Width, point type, and point size must not be negative: Got: %f, %d, %f",
+			style.Width, style.PointType, style.PointSize,
+		)
+	}
+
+	cmd := fmt.Sprintf("set style line %d", id)
+	if style.Color != "" {
+		cmd += " linecolor rgb " + escapeOps(quoteText(style.Color))
+	}
+	if style.Width > 0 {
+		cmd += " linewidth " + strconv.FormatFloat(style.Width, 'g', -1, 64)
+	}
+	if style.Dash != "" {
+		cmd += " dashtype " + style.Dash
+	}
+	if style.PointType > 0 {
+		cmd += fmt.Sprintf(" pointtype %d", style.PointType)
+	}
+	if style.PointSize > 0 {
+		cmd += " pointsize " + strconv.FormatFloat(style.PointSize, 'g', -1, 64)
+	}
+	return g.Cmds(cmd)
+}
+
+// Writes the cmd that defines a function with the supplied signature and body,
+// such as a function that will later be fitted to data with [GnuPlot.Fit]. The
+// following cmd will be written:
+//
+//	<signature> = <body>
+//
+// The signature must be of the form `name(arg1, arg2, ...)` with at least one
+// argument, such as `f(x)`. If the signature is invalid or the body is empty a
+// [InvalidFuncErr] will be returned.
+func (g *GnuPlot) DefineFunc(signature string, body string) error {
+	signature = strings.TrimSpace(signature)
+	if !funcSigRegex.MatchString(signature) {
+		return sberr.Wrap(
+			InvalidFuncErr,
+			"Expected a signature of the form name(args): Got: %q", signature,
+		)
+	}
+	if strings.TrimSpace(body) == "" {
+		return sberr.Wrap(InvalidFuncErr, "The function body was empty")
+	}
+	return g.Cmds(signature + " = " + body)
+}
+
+// Writes the cmd that defines a variable with the supplied name and value so
+// it can be reused by later cmds. The following cmd will be written:
+//
+//	<name> = <value>
+//
+// The value is formatted based on its type:
+//   - int and int64 values are written as integers
+//   - float64 values are always written with a decimal point or exponent so
+//     that gnuplot does not treat them as integers, which would change the
+//     result of division
+//   - bool values are written as 1 or 0
+//   - string and [fmt.Stringer] values are quoted and escaped in the same way
+//     as [GnuPlot.SetTitle], so they are written literally
+//
+// If the name is not a valid gnuplot identifier or the value is a NaN or
+// infinite float a [InvalidVarErr] will be returned. If the value is any other
+// type a [UnsupportedDataTypeErr] will be returned.
+func (g *GnuPlot) SetVar(name string, value any) error {
+	if !identRegex.MatchString(name) {
+		return sberr.Wrap(
+			InvalidVarErr, "Name is not a valid identifier: Got: %q", name,
+		)
+	}
+	var strVal string
+	switch v := value.(type) {
+	case int:
+		strVal = strconv.Itoa(v)
+	case int64:
+		strVal = strconv.FormatInt(v, 10)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return sberr.Wrap(
+				InvalidVarErr, "Value must be finite: Name: %s Got: %f", name, v,
+			)
+		}
+		strVal = strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(strVal, ".e") {
+			strVal += ".0"
+		}
+	case bool:
+		strVal = "0"
+		if v {
+			strVal = "1"
+		}
+	case string:
+		strVal = escapeOps(quoteText(v))
+	case fmt.Stringer:
+		strVal = escapeOps(quoteText(v.String()))
+	default:
+		return sberr.Wrap(
+			UnsupportedDataTypeErr, "Name: %s Got: %T", name, value,
+		)
+	}
+	return g.Cmds(name + " = " + strVal)
+}
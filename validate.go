@@ -0,0 +1,95 @@
+package sbgnuplot
+
+import (
+	"errors"
+	"strings"
+
+	sberr "github.com/barbell-math/smoothbrain-errs"
+)
+
+type (
+	// A rule that is checked by [GnuPlot.Validate]. The rule is given the
+	// generated gnu plot code split into lines and should return an error for
+	// each violation that it finds.
+	LintRule func(g *GnuPlot, lines []string) []error
+)
+
+var (
+	PlotWithoutOutputErr = errors.New("Plot without output")
+
+	// The rules that [GnuPlot.Validate] will check when
+	// [GnuPlotOpts.LintRules] is nil.
+	DefaultLintRules = []LintRule{
+		PlotWithoutOutputRule,
+		EmptyDatRule,
+	}
+)
+
+// Statically checks the gnu plot code that has been generated so far against
+// the rules in [GnuPlotOpts.LintRules], or [DefaultLintRules] if no rules were
+// supplied. All violations from all rules will be returned. Gnuplot does not
+// need to be installed to call this method and no files are closed, so more
+// cmds can be added after calling it. Note that invalid ops, such as a
+// `{dat:#}` op with an undefined index, are already rejected by
+// [GnuPlot.Cmds].
+func (g *GnuPlot) Validate() []error {
+	script, err := g.Script()
+	if err != nil {
+		return []error{err}
+	}
+	lines := strings.Split(script, "\n")
+
+	rules := g.opts.LintRules
+	if rules == nil {
+		rules = DefaultLintRules
+	}
+	rv := []error{}
+	for _, r := range rules {
+		rv = append(rv, r(g, lines)...)
+	}
+	return rv
+}
+
+// A [LintRule] that returns a [PlotWithoutOutputErr] for the first `plot`,
+// `splot`, or `replot` cmd that is not preceded by a `set output` cmd.
+func PlotWithoutOutputRule(g *GnuPlot, lines []string) []error {
+	for i, l := range lines {
+		l = strings.TrimSpace(l)
+		if strings.HasPrefix(l, "set output") {
+			return nil
+		}
+		if isPlotCmd(l, "plot", "splot", "replot") {
+			return []error{sberr.Wrap(
+				PlotWithoutOutputErr,
+				"No 'set output' cmd before plot cmd: Line: %d", i+1,
+			)}
+		}
+	}
+	return nil
+}
+
+// A [LintRule] that returns a [EmptyDatFileErr] for each dat file that is
+// referenced by a `{dat:#}` op but has not had any data written to it.
+func EmptyDatRule(g *GnuPlot, lines []string) []error {
+	rv := []error{}
+	for i, referenced := range g.datRefs {
+		if referenced && !g.hasData[i] {
+			rv = append(rv, sberr.Wrap(
+				EmptyDatFileErr,
+				"No data was written to referenced dat file: Index: %d Path: %s",
+				i, g.datNames[i],
+			))
+		}
+	}
+	return rv
+}
+
+func isPlotCmd(l string, cmds ...string) bool {
+	word, _, _ := strings.Cut(l, " ")
+	for _, c := range cmds {
+		if word == c {
+			return true
+		}
+	}
+	return false
+}